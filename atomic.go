@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// revertTimeout bounds the revert of an atomic deploy, waits included, once
+// the run itself is done.
+const revertTimeout = 5 * time.Minute
+
+// priorState is the live version of the manifest at position before the
+// deploy, nil when the deploy creates it. pruned marks the older generated
+// objects the deploy may delete.
+type priorState struct {
+	position int
+	manifest manifest
+	live     runtime.Object
+	pruned   bool
+}
+
+// recordPriorStates reads the live version of every object of manifests,
+// and of the generated objects about to be pruned, so that an atomic deploy
+// can revert them. Objects of kinds the plugin does not apply are skipped,
+// as they are left untouched.
+func (p Plugin) recordPriorStates(ctx context.Context, clientset *kubernetes.Clientset, manifests []manifest) ([]priorState, error) {
+	var states []priorState
+	for i, m := range manifests {
+		if !isSupported(m.Object) {
+			continue
+		}
+		var live runtime.Object
+		err := p.retry(ctx, "get "+m.String(), func() error {
+			var err error
+			live, err = p.getLive(clientset, m.Object)
+			return err
+		})
+		if errors.IsNotFound(err) {
+			live, err = nil, nil
+		}
+		if err != nil {
+			log.Println("Error when getting the live version of " + m.String())
+			return nil, err
+		}
+		states = append(states, priorState{position: i, manifest: m, live: live})
+	}
+
+	if p.Config.PruneGenerated {
+		pruned, err := p.prunableGenerated(ctx, clientset, manifests)
+		if err != nil {
+			return nil, err
+		}
+		for i, obj := range pruned {
+			states = append(states, priorState{manifest: newManifest("live", i+1, 0, obj), live: obj, pruned: true})
+		}
+	}
+	return states, nil
+}
+
+// revert brings back the objects touched by a failed deploy to their prior
+// state, in reverse order: objects that existed are updated back, the other
+// ones are deleted. Only the first applied manifests, and the one that failed,
+// were touched, besides the pruned objects. It then waits for the reverted
+// workloads and prints the final state of every object.
+func (p Plugin) revert(ctx context.Context, states []priorState, applied int) error {
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), revertTimeout)
+		defer cancel()
+	}
+	// the client of the run is bound to its context, which may be done
+	clientset, err := p.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	var touched []priorState
+	for _, state := range states {
+		if state.position <= applied || state.pruned {
+			touched = append(touched, state)
+		}
+	}
+
+	log.Printf("Reverting %d objects", len(touched))
+	var report []string
+	var restored []manifest
+	failed := 0
+	for i := len(touched) - 1; i >= 0; i-- {
+		m, live := touched[i].manifest, touched[i].live
+		if !isSupported(m.Object) {
+			continue
+		}
+		if live == nil {
+			err = p.retry(ctx, "deleting "+m.String(), func() error {
+				return p.deleteLive(clientset, m.Object)
+			})
+			if errors.IsNotFound(err) {
+				err = nil
+			}
+			if err == nil {
+				report = append(report, "Deleted: "+m.String())
+				continue
+			}
+		} else {
+			var prior runtime.Object
+			prior, err = withoutServerFields(live)
+			if err == nil {
+				err = p.retry(ctx, "restoring "+m.String(), func() error {
+					return p.apply(clientset, prior)
+				})
+			}
+			if err == nil {
+				report = append(report, "Restored: "+m.String())
+				restored = append(restored, newManifest(m.Source, m.Index, m.Item, prior))
+				continue
+			}
+		}
+		log.Println("Error when reverting " + m.String() + ": " + err.Error())
+		report = append(report, "Not reverted: "+m.String())
+		failed++
+	}
+
+	if failed == 0 {
+		if err := p.waitForRollouts(ctx, clientset, restored); err != nil {
+			report = append(report, "Reverted workloads not rolled out: "+err.Error())
+			failed++
+		}
+	}
+
+	for _, line := range report {
+		log.Println(line)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d objects could not be reverted", failed)
+	}
+	return nil
+}
+
+// withoutServerFields returns a copy of a live object without the metadata
+// and status set by the API server, so that it can be applied again.
+func withoutServerFields(live runtime.Object) (runtime.Object, error) {
+	u, err := toUnstructured(live)
+	if err != nil {
+		return nil, err
+	}
+	stripServerFields(u)
+	return fromUnstructured(u)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// checksumAnnotationPrefix prefixes the annotations holding the hash of a
+// ConfigMap or Secret in the pod templates using it.
+const checksumAnnotationPrefix = "checksum/"
+
+// applyChecksums annotates the pod template of every workload with a hash of
+// each rendered ConfigMap and Secret it references, so that a configuration
+// change rolls exactly the Pods depending on it.
+func (p Plugin) applyChecksums(manifests []manifest) error {
+	// hashes of the ConfigMaps and Secrets by kind, namespace and name
+	contents := map[string]string{}
+	for _, m := range manifests {
+		if m.Kind != "ConfigMap" && m.Kind != "Secret" {
+			continue
+		}
+		u, err := toUnstructured(m.Object)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"data":       u["data"],
+			"binaryData": u["binaryData"],
+			"stringData": u["stringData"],
+		})
+		if err != nil {
+			return err
+		}
+		contents[m.Kind+"/"+p.namespaceOf(m.Object)+"/"+m.Name] = hexDigest(data)
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+
+	return transformManifests(manifests, func(m manifest, u map[string]interface{}) error {
+		template := podTemplate(u)
+		if template == nil || m.Kind == "Pod" {
+			return nil
+		}
+
+		// referenced hashes by name, then by kind
+		namespace := p.namespaceOf(m.Object)
+		references := map[string]map[string]string{}
+		visitReferences(u, func(kind, name string) string {
+			if kind != "ConfigMap" && kind != "Secret" {
+				return name
+			}
+			if hash, ok := contents[kind+"/"+namespace+"/"+name]; ok {
+				if references[name] == nil {
+					references[name] = map[string]string{}
+				}
+				references[name][kind] = hash
+			}
+			return name
+		})
+
+		// a ConfigMap and a Secret sharing a name get an annotation each
+		checksums := map[string]string{}
+		for name, hashes := range references {
+			for kind, hash := range hashes {
+				key := checksumAnnotationPrefix + name
+				if len(hashes) > 1 {
+					key = checksumAnnotationPrefix + strings.ToLower(kind) + "-" + name
+				}
+				checksums[key] = hash
+			}
+		}
+		setStringMapEntries(template, checksums, "metadata", "annotations")
+		return nil
+	})
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -34,11 +38,278 @@ func main() {
 			Usage:  "Kubernetes namespace",
 			EnvVar: "PLUGIN_KUBERNETES_NAMESPACE",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:   "template",
-			Usage:  "Kubernetes template",
+			Usage:  "Kubernetes templates: files, directories, glob patterns or URLs",
 			EnvVar: "PLUGIN_KUBERNETES_TEMPLATE",
 		},
+		cli.StringFlag{
+			Name:   "action",
+			Usage:  "action to run: apply, render_only, set-image, scale, pause, resume, restart, rollback, history or restore",
+			Value:  "apply",
+			EnvVar: "PLUGIN_ACTION",
+		},
+		cli.StringFlag{
+			Name:   "targets",
+			Usage:  "workloads targeted by the workload actions",
+			EnvVar: "PLUGIN_TARGETS",
+		},
+		cli.StringFlag{
+			Name:   "container_images",
+			Usage:  "images set by container name",
+			EnvVar: "PLUGIN_CONTAINER_IMAGES",
+		},
+		cli.IntFlag{
+			Name:   "replicas",
+			Usage:  "replicas set by the scale action",
+			Value:  -1,
+			EnvVar: "PLUGIN_REPLICAS",
+		},
+		cli.IntFlag{
+			Name:   "revision",
+			Usage:  "revision restored by the rollback action",
+			EnvVar: "PLUGIN_REVISION",
+		},
+		cli.IntFlag{
+			Name:   "rollback_build",
+			Usage:  "build the revision restored by the rollback action was deployed by",
+			EnvVar: "PLUGIN_ROLLBACK_BUILD",
+		},
+		cli.StringFlag{
+			Name:   "output_file",
+			Usage:  "file the final manifests are written to",
+			EnvVar: "PLUGIN_OUTPUT_FILE",
+		},
+		cli.StringFlag{
+			Name:   "template_ca",
+			Usage:  "base64 encoded PEM CA used to verify template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_CA",
+		},
+		cli.BoolFlag{
+			Name:   "template_skip_verify",
+			Usage:  "skip TLS verification of template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_SKIP_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "template_token",
+			Usage:  "bearer token sent when fetching template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "template_username",
+			Usage:  "basic auth username sent when fetching template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "template_password",
+			Usage:  "basic auth password sent when fetching template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "template_headers",
+			Usage:  "headers sent when fetching template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_HEADERS",
+		},
+		cli.DurationFlag{
+			Name:   "template_timeout",
+			Usage:  "timeout of a template URL request",
+			Value:  30 * time.Second,
+			EnvVar: "PLUGIN_TEMPLATE_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "template_sha256",
+			Usage:  "expected SHA-256 of template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_SHA256",
+		},
+		cli.StringSliceFlag{
+			Name:   "template_public_keys",
+			Usage:  "ed25519 public keys verifying template URL signatures",
+			EnvVar: "PLUGIN_TEMPLATE_PUBLIC_KEYS",
+		},
+		cli.StringFlag{
+			Name:   "template_signature",
+			Usage:  "detached signature of template URLs",
+			EnvVar: "PLUGIN_TEMPLATE_SIGNATURE",
+		},
+		cli.BoolFlag{
+			Name:   "require_signature",
+			Usage:  "fail when a template URL is not signed",
+			EnvVar: "PLUGIN_REQUIRE_SIGNATURE",
+		},
+		cli.BoolFlag{
+			Name:   "lookup_offline",
+			Usage:  "render template lookups as empty objects",
+			EnvVar: "PLUGIN_LOOKUP_OFFLINE",
+		},
+		cli.StringFlag{
+			Name:   "engine",
+			Usage:  "template engine: handlebars or gotemplate",
+			Value:  "handlebars",
+			EnvVar: "PLUGIN_ENGINE",
+		},
+		cli.BoolFlag{
+			Name:   "strict",
+			Usage:  "fail the rendering on unresolved template references",
+			EnvVar: "PLUGIN_STRICT",
+		},
+		cli.StringFlag{
+			Name:   "vars",
+			Usage:  "template values, as a JSON object",
+			EnvVar: "PLUGIN_VARS",
+		},
+		cli.StringSliceFlag{
+			Name:   "values_files",
+			Usage:  "YAML or JSON values files, merged in order",
+			EnvVar: "PLUGIN_VALUES_FILES",
+		},
+		cli.StringSliceFlag{
+			Name:   "patches",
+			Usage:  "strategic merge patch files applied to the rendered documents",
+			EnvVar: "PLUGIN_PATCHES",
+		},
+		cli.StringFlag{
+			Name:   "json_patches",
+			Usage:  "JSON6902 patches with their targets, as a JSON list",
+			EnvVar: "PLUGIN_JSON_PATCHES",
+		},
+		cli.StringFlag{
+			Name:   "images",
+			Usage:  "container image overrides, as a JSON list",
+			EnvVar: "PLUGIN_IMAGES",
+		},
+		cli.StringFlag{
+			Name:   "common_labels",
+			Usage:  "labels added to every object, as a JSON object",
+			EnvVar: "PLUGIN_COMMON_LABELS",
+		},
+		cli.StringFlag{
+			Name:   "common_annotations",
+			Usage:  "annotations added to every object, as a JSON object",
+			EnvVar: "PLUGIN_COMMON_ANNOTATIONS",
+		},
+		cli.StringFlag{
+			Name:   "name_prefix",
+			Usage:  "prefix added to every object name",
+			EnvVar: "PLUGIN_NAME_PREFIX",
+		},
+		cli.StringFlag{
+			Name:   "name_suffix",
+			Usage:  "suffix added to every object name",
+			EnvVar: "PLUGIN_NAME_SUFFIX",
+		},
+		cli.StringFlag{
+			Name:   "namespace_override",
+			Usage:  "namespace forced on every namespaced object",
+			EnvVar: "PLUGIN_NAMESPACE_OVERRIDE",
+		},
+		cli.StringFlag{
+			Name:   "generators",
+			Usage:  "ConfigMap and Secret generators, as a JSON list",
+			EnvVar: "PLUGIN_GENERATORS",
+		},
+		cli.BoolFlag{
+			Name:   "prune_generated",
+			Usage:  "delete older generated ConfigMaps and Secrets once rolled out",
+			EnvVar: "PLUGIN_PRUNE_GENERATED",
+		},
+		cli.BoolFlag{
+			Name:   "config_checksums",
+			Usage:  "annotate pod templates with the checksum of the ConfigMaps and Secrets they use",
+			EnvVar: "PLUGIN_CONFIG_CHECKSUMS",
+		},
+		cli.BoolFlag{
+			Name:   "provenance",
+			Usage:  "label and annotate objects with the build that applied them",
+			EnvVar: "PLUGIN_PROVENANCE",
+		},
+		cli.StringFlag{
+			Name:   "provenance_prefix",
+			Usage:  "prefix of the provenance label and annotation keys",
+			Value:  "drone-kubernetes/",
+			EnvVar: "PLUGIN_PROVENANCE_PREFIX",
+		},
+		cli.StringSliceFlag{
+			Name:   "provenance_labels",
+			Usage:  "build fields set as labels",
+			Value:  &cli.StringSlice{"repo", "commit", "branch", "build"},
+			EnvVar: "PLUGIN_PROVENANCE_LABELS",
+		},
+		cli.StringSliceFlag{
+			Name:   "provenance_annotations",
+			Usage:  "build fields set as annotations",
+			Value:  &cli.StringSlice{"repo", "commit", "branch", "build", "link", "author"},
+			EnvVar: "PLUGIN_PROVENANCE_ANNOTATIONS",
+		},
+		cli.BoolFlag{
+			Name:   "force_rollout",
+			Usage:  "roll out workloads even when unchanged",
+			EnvVar: "PLUGIN_FORCE_ROLLOUT",
+		},
+		cli.BoolFlag{
+			Name:   "release_ledger",
+			Usage:  "record every deploy in the target namespace",
+			EnvVar: "PLUGIN_RELEASE_LEDGER",
+		},
+		cli.StringFlag{
+			Name:   "release_ledger_kind",
+			Usage:  "kind of the release records: Secret or ConfigMap",
+			Value:  "Secret",
+			EnvVar: "PLUGIN_RELEASE_LEDGER_KIND",
+		},
+		cli.StringFlag{
+			Name:   "release_name",
+			Usage:  "name release records are grouped by, the repository name by default",
+			EnvVar: "PLUGIN_RELEASE_NAME",
+		},
+		cli.IntFlag{
+			Name:   "history_limit",
+			Usage:  "number of release records kept, 0 for all",
+			Value:  10,
+			EnvVar: "PLUGIN_HISTORY_LIMIT",
+		},
+		cli.StringFlag{
+			Name:   "snapshot_file",
+			Usage:  "file live objects are saved to before being updated",
+			EnvVar: "PLUGIN_SNAPSHOT_FILE",
+		},
+		cli.StringFlag{
+			Name:   "snapshot_configmap",
+			Usage:  "ConfigMap live objects are saved to before being updated",
+			EnvVar: "PLUGIN_SNAPSHOT_CONFIGMAP",
+		},
+		cli.BoolFlag{
+			Name:   "atomic",
+			Usage:  "revert every change when any step of the deploy fails",
+			EnvVar: "PLUGIN_ATOMIC",
+		},
+		cli.BoolFlag{
+			Name:   "continue_on_error",
+			Usage:  "apply every object even when some fail, and report all failures",
+			EnvVar: "PLUGIN_CONTINUE_ON_ERROR",
+		},
+		cli.BoolFlag{
+			Name:   "wait",
+			Usage:  "wait for Deployments, StatefulSets and DaemonSets to roll out",
+			EnvVar: "PLUGIN_WAIT",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "overall deadline for the deployment, 0 to disable",
+			Value:  10 * time.Minute,
+			EnvVar: "PLUGIN_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "retries",
+			Usage:  "maximum number of retries on transient API server errors",
+			Value:  5,
+			EnvVar: "PLUGIN_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "retry_budget",
+			Usage:  "maximum time spent retrying a single object",
+			Value:  2 * time.Minute,
+			EnvVar: "PLUGIN_RETRY_BUDGET",
+		},
 		cli.StringFlag{
 			Name:   "repo.owner",
 			Usage:  "repository owner",
@@ -49,6 +320,11 @@ func main() {
 			Usage:  "repository name",
 			EnvVar: "DRONE_REPO_NAME",
 		},
+		cli.StringFlag{
+			Name:   "repo.link",
+			Usage:  "repository link",
+			EnvVar: "DRONE_REPO_LINK",
+		},
 		cli.StringFlag{
 			Name:   "commit.sha",
 			Usage:  "git commit sha",
@@ -71,6 +347,11 @@ func main() {
 			Usage:  "git author name",
 			EnvVar: "DRONE_COMMIT_AUTHOR",
 		},
+		cli.StringFlag{
+			Name:   "commit.message",
+			Usage:  "git commit message",
+			EnvVar: "DRONE_COMMIT_MESSAGE",
+		},
 		cli.StringFlag{
 			Name:   "build.event",
 			Value:  "push",
@@ -108,6 +389,51 @@ func main() {
 			Usage:  "build tag",
 			EnvVar: "DRONE_TAG",
 		},
+		cli.IntFlag{
+			Name:   "build.pull_request",
+			Usage:  "pull request number",
+			EnvVar: "DRONE_PULL_REQUEST",
+		},
+		cli.StringFlag{
+			Name:   "build.source_branch",
+			Usage:  "pull request source branch",
+			EnvVar: "DRONE_SOURCE_BRANCH",
+		},
+		cli.StringFlag{
+			Name:   "build.target_branch",
+			Usage:  "pull request target branch",
+			EnvVar: "DRONE_TARGET_BRANCH",
+		},
+		cli.StringFlag{
+			Name:   "build.deploy_to",
+			Usage:  "deployment target",
+			EnvVar: "DRONE_DEPLOY_TO",
+		},
+		cli.IntFlag{
+			Name:   "build.parent",
+			Usage:  "parent build number",
+			EnvVar: "DRONE_BUILD_PARENT",
+		},
+		cli.Int64Flag{
+			Name:   "job.started",
+			Usage:  "job started",
+			EnvVar: "DRONE_JOB_STARTED,DRONE_STAGE_STARTED",
+		},
+		cli.StringFlag{
+			Name:   "job.stage",
+			Usage:  "stage name",
+			EnvVar: "DRONE_STAGE_NAME",
+		},
+		cli.StringFlag{
+			Name:   "job.step",
+			Usage:  "step name",
+			EnvVar: "DRONE_STEP_NAME",
+		},
+		cli.StringFlag{
+			Name:   "job.runner",
+			Usage:  "runner host name",
+			EnvVar: "DRONE_RUNNER_HOSTNAME,DRONE_MACHINE",
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -121,6 +447,7 @@ func run(c *cli.Context) error {
 		Repo: Repo{
 			Owner: c.String("repo.owner"),
 			Name:  c.String("repo.name"),
+			Link:  c.String("repo.link"),
 		},
 		Build: Build{
 			Tag:     c.String("build.tag"),
@@ -134,18 +461,121 @@ func run(c *cli.Context) error {
 			Link:    c.String("build.link"),
 			Started: c.Int64("build.started"),
 			Created: c.Int64("build.created"),
+
+			Message:      c.String("commit.message"),
+			PullRequest:  c.Int("build.pull_request"),
+			SourceBranch: c.String("build.source_branch"),
+			TargetBranch: c.String("build.target_branch"),
+			DeployTo:     c.String("build.deploy_to"),
+			Parent:       c.Int("build.parent"),
 		},
 		Job: Job{
 			Started: c.Int64("job.started"),
+			Stage:   c.String("job.stage"),
+			Step:    c.String("job.step"),
+			Runner:  c.String("job.runner"),
 		},
 		Config: Config{
 			Token:     c.String("token"),
 			Server:    c.String("server"),
 			Cert:      c.String("cert"),
 			Namespace: c.String("namespace"),
-			Template:  c.String("template"),
+			Templates: c.StringSlice("template"),
+			Engine:    c.String("engine"),
+			Strict:    c.Bool("strict"),
+
+			Vars:        c.String("vars"),
+			ValuesFiles: c.StringSlice("values_files"),
+
+			Patches:           c.StringSlice("patches"),
+			JSONPatches:       c.String("json_patches"),
+			Images:            c.String("images"),
+			CommonLabels:      c.String("common_labels"),
+			CommonAnnotations: c.String("common_annotations"),
+			NamePrefix:        c.String("name_prefix"),
+			NameSuffix:        c.String("name_suffix"),
+			NamespaceOverride: c.String("namespace_override"),
+
+			Generators:      c.String("generators"),
+			PruneGenerated:  c.Bool("prune_generated"),
+			ConfigChecksums: c.Bool("config_checksums"),
+			Wait:            c.Bool("wait"),
+
+			Timeout:     c.Duration("timeout"),
+			Retries:     c.Int("retries"),
+			RetryBudget: c.Duration("retry_budget"),
+
+			Action:     c.String("action"),
+			OutputFile: c.String("output_file"),
+
+			Targets:         c.String("targets"),
+			ContainerImages: c.String("container_images"),
+			Replicas:        c.Int("replicas"),
+			Revision:        c.Int("revision"),
+			RollbackBuild:   c.Int("rollback_build"),
+
+			TemplateCA:         c.String("template_ca"),
+			TemplateSkipVerify: c.Bool("template_skip_verify"),
+			TemplateToken:      c.String("template_token"),
+			TemplateUsername:   c.String("template_username"),
+			TemplatePassword:   c.String("template_password"),
+			TemplateHeaders:    c.String("template_headers"),
+			TemplateTimeout:    c.Duration("template_timeout"),
+			TemplateSHA256:     c.String("template_sha256"),
+
+			TemplatePublicKeys: c.StringSlice("template_public_keys"),
+			TemplateSignature:  c.String("template_signature"),
+			RequireSignature:   c.Bool("require_signature"),
+
+			LookupOffline: c.Bool("lookup_offline"),
+
+			Provenance:            c.Bool("provenance"),
+			ProvenancePrefix:      c.String("provenance_prefix"),
+			ProvenanceLabels:      c.StringSlice("provenance_labels"),
+			ProvenanceAnnotations: c.StringSlice("provenance_annotations"),
+
+			ForceRollout: c.Bool("force_rollout"),
+
+			ReleaseLedger:     c.Bool("release_ledger"),
+			ReleaseLedgerKind: c.String("release_ledger_kind"),
+			ReleaseName:       c.String("release_name"),
+			HistoryLimit:      c.Int("history_limit"),
+
+			SnapshotFile:      c.String("snapshot_file"),
+			SnapshotConfigMap: c.String("snapshot_configmap"),
+
+			Atomic:          c.Bool("atomic"),
+			ContinueOnError: c.Bool("continue_on_error"),
 		},
 	}
 
-	return plugin.Exec()
+	if plugin.Build.Tag != "" {
+		// a tag that is not a semantic version simply leaves Semver unset
+		plugin.Build.Semver, _ = parseSemver(plugin.Build.Tag)
+	}
+
+	ctx := context.Background()
+	if plugin.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, plugin.Config.Timeout)
+		defer cancel()
+	}
+
+	// Drone sends SIGTERM when a build is cancelled, abort the in-flight
+	// request and let Exec report what was applied
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Println("Received " + sig.String() + ", aborting")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return plugin.Exec(ctx)
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// objectError is the failure to apply one manifest of a deploy.
+type objectError struct {
+	Position  int
+	Manifest  manifest
+	Namespace string
+	Reason    string
+	Err       error
+}
+
+// newObjectError wraps the error returned when applying the manifest at
+// position, with the reason given by the API server if any.
+func (p Plugin) newObjectError(position int, m manifest, err error) objectError {
+	e := objectError{Position: position, Manifest: m, Reason: string(errors.ReasonForError(err)), Err: err}
+	if isNamespaced(m.Kind) {
+		e.Namespace = p.namespaceOf(m.Object)
+	}
+	return e
+}
+
+func (e objectError) Error() string {
+	name := e.Manifest.Name
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + name
+	}
+	location := fmt.Sprintf("%s, document %d", e.Manifest.Source, e.Manifest.Index)
+	if e.Manifest.Item > 0 {
+		location += fmt.Sprintf(", item %d", e.Manifest.Item)
+	}
+	if e.Reason == "" {
+		return fmt.Sprintf("%s %s (%s): %v", e.Manifest.Kind, name, location, e.Err)
+	}
+	return fmt.Sprintf("%s %s (%s): %s: %v", e.Manifest.Kind, name, location, e.Reason, e.Err)
+}
+
+// errUnsupportedKind is the reason objects of kinds the plugin does not
+// apply are skipped.
+var errUnsupportedKind = fmt.Errorf("kind not supported by the plugin")
+
+// objectErrors collects the outcome of the manifests of a deploy: the
+// failures, when the deploy went on applying the other manifests, and the
+// objects skipped as their kind is not supported.
+type objectErrors struct {
+	Errors  []objectError
+	Skipped []objectError
+	Applied int
+	Total   int
+}
+
+func (e *objectErrors) Error() string {
+	return fmt.Sprintf("%d of %d objects failed to apply", len(e.Errors), e.Total)
+}
+
+// failed reports whether the manifest at position failed to apply or was
+// skipped.
+func (e *objectErrors) failed(position int) bool {
+	for _, objectErr := range append(e.Errors, e.Skipped...) {
+		if objectErr.Position == position {
+			return true
+		}
+	}
+	return false
+}
+
+// printSummary prints every object that was skipped or failed, then the
+// counts.
+func (e *objectErrors) printSummary() {
+	for _, objectErr := range e.Skipped {
+		log.Println("Skipped: " + objectErr.Error())
+	}
+	for _, objectErr := range e.Errors {
+		log.Println("Failed: " + objectErr.Error())
+	}
+	summary := fmt.Sprintf("Summary: %d applied, %d skipped, %d failed", e.Applied, len(e.Skipped), len(e.Errors))
+	if rest := e.Total - e.Applied - len(e.Skipped) - len(e.Errors); rest > 0 {
+		summary += fmt.Sprintf(", %d not attempted", rest)
+	}
+	log.Println(summary)
+}
+
+// appliedObject reports whether the manifest m at position was applied by a
+// deploy that stopped after applied manifests with deployErr.
+func appliedObject(position int, m manifest, applied int, deployErr error) bool {
+	if !isSupported(m.Object) {
+		return false
+	}
+	if failures, ok := deployErr.(*objectErrors); ok && failures.failed(position) {
+		return false
+	}
+	return position < applied
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// httpStatusError is returned when a template URL answers with a non-2xx
+// status.
+type httpStatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// retryable reports whether the request may succeed if sent again.
+func (e *httpStatusError) retryable() bool {
+	return e.Code == http.StatusTooManyRequests ||
+		e.Code == http.StatusRequestTimeout ||
+		(e.Code >= http.StatusInternalServerError && e.Code != http.StatusNotImplemented)
+}
+
+// fetchTemplate downloads a template URL, with retries, and checks its
+// checksum and signature when configured.
+func (p Plugin) fetchTemplate(ctx context.Context, source string) (string, error) {
+	out, err := p.download(ctx, source)
+	if err != nil {
+		log.Println("Error when getting template URL " + source)
+		return "", err
+	}
+
+	if err := p.verifyChecksum(source, out); err != nil {
+		return "", err
+	}
+	if err := p.verifySignature(ctx, source, out); err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// download GETs url with the template client, authentication and headers.
+func (p Plugin) download(ctx context.Context, url string) ([]byte, error) {
+	client, err := p.templateClient()
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{}
+	if err := decodeSetting("template_headers", p.Config.TemplateHeaders, &headers); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	err = p.retry(ctx, "GET "+url, func() error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		switch {
+		case p.Config.TemplateToken != "":
+			req.Header.Set("Authorization", "Bearer "+p.Config.TemplateToken)
+		case p.Config.TemplateUsername != "":
+			req.SetBasicAuth(p.Config.TemplateUsername, p.Config.TemplatePassword)
+		}
+
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return &httpStatusError{URL: url, Code: res.StatusCode}
+		}
+		out, err = ioutil.ReadAll(res.Body)
+		return err
+	})
+	return out, err
+}
+
+// templateClient returns the HTTP client used to fetch templates. Server
+// certificates are verified against the system roots, plus template_ca when
+// set.
+func (p Plugin) templateClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.Config.TemplateSkipVerify}
+	if p.Config.TemplateCA != "" {
+		ca, err := base64.StdEncoding.DecodeString(p.Config.TemplateCA)
+		if err != nil {
+			log.Println("Error when decoding template_ca")
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("template_ca does not contain any PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	transport := &http.Transport{
+		Proxy:                 defaultTransport.Proxy,
+		DialContext:           defaultTransport.DialContext,
+		MaxIdleConns:          defaultTransport.MaxIdleConns,
+		IdleConnTimeout:       defaultTransport.IdleConnTimeout,
+		ExpectContinueTimeout: defaultTransport.ExpectContinueTimeout,
+		TLSHandshakeTimeout:   defaultTransport.TLSHandshakeTimeout,
+		TLSClientConfig:       tlsConfig,
+	}
+	return &http.Client{Transport: transport, Timeout: p.Config.TemplateTimeout}, nil
+}
+
+// verifyChecksum compares the SHA-256 of a fetched template with
+// template_sha256. The setting holds either a single digest, used for every
+// URL, or a JSON map of URLs to digests.
+func (p Plugin) verifyChecksum(source string, content []byte) error {
+	pinned := strings.TrimSpace(p.Config.TemplateSHA256)
+	if pinned == "" {
+		return nil
+	}
+	if strings.HasPrefix(pinned, "{") {
+		digests := map[string]string{}
+		if err := decodeSetting("template_sha256", pinned, &digests); err != nil {
+			return err
+		}
+		var ok bool
+		if pinned, ok = digests[source]; !ok {
+			return fmt.Errorf("no template_sha256 for %s", source)
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(strings.TrimPrefix(pinned, "sha256:"), actual) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", source, pinned, actual)
+	}
+	return nil
+}
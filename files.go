@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aymerick/raymond"
+	"github.com/ghodss/yaml"
+)
+
+// File helpers give templates read access to the workspace, the working
+// directory of the step. Paths are relative to it and may not leave it, even
+// through symbolic links.
+
+func init() {
+	raymond.RegisterHelpers(fileFuncs)
+
+	goFuncs["readFile"] = readFile
+	goFuncs["readFileIndent"] = readFileIndent
+	goFuncs["readFileBase64"] = readFileBase64
+	goFuncs["glob"] = globFiles
+	goFuncs["readData"] = readData
+}
+
+// fileFuncs are the handlebars versions of the file helpers. Text is
+// returned as raymond.SafeString so that it is not HTML escaped.
+var fileFuncs = map[string]interface{}{
+	"readFile": func(path string) raymond.SafeString {
+		return raymond.SafeString(mustString(readFile(path)))
+	},
+	"readFileIndent": func(spaces interface{}, path string) raymond.SafeString {
+		return raymond.SafeString(mustString(readFileIndent(spaces, path)))
+	},
+	"readFileBase64": func(path string) raymond.SafeString {
+		return raymond.SafeString(mustString(readFileBase64(path)))
+	},
+	"glob": func(pattern string) []string {
+		files, err := globFiles(pattern)
+		if err != nil {
+			panic(err)
+		}
+		return files
+	},
+	"readData": func(path string) interface{} {
+		data, err := readData(path)
+		if err != nil {
+			panic(err)
+		}
+		return data
+	},
+}
+
+// mustString panics with err, which raymond turns into a rendering error, as
+// handlebars helpers cannot return one.
+func mustString(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// workspacePath resolves path against the workspace and fails when the
+// result lies outside of it.
+func workspacePath(path string) (string, error) {
+	root, err := os.Getwd()
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	file := path
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(root, file)
+	}
+	file, err = filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(root, file) {
+		return "", fmt.Errorf("%s is outside of the workspace", path)
+	}
+	return file, nil
+}
+
+func withinDir(dir, file string) bool {
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func readFile(path string) (string, error) {
+	file, err := workspacePath(path)
+	if err != nil {
+		return "", err
+	}
+	out, err := ioutil.ReadFile(file)
+	return string(out), err
+}
+
+// readFileIndent returns the content of a file with every line indented, to
+// embed it in a YAML block scalar.
+func readFileIndent(spaces interface{}, path string) (string, error) {
+	out, err := readFile(path)
+	if err != nil {
+		return "", err
+	}
+	return indentAny(spaces, strings.TrimRight(out, "\n"))
+}
+
+func readFileBase64(path string) (string, error) {
+	out, err := readFile(path)
+	return base64.StdEncoding.EncodeToString([]byte(out)), err
+}
+
+// globFiles returns the workspace files matching pattern, sorted, relative
+// to the workspace.
+func globFiles(pattern string) ([]string, error) {
+	root, err := workspacePath(".")
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(root, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, match := range matches {
+		file, err := workspacePath(match)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(file); err != nil || info.IsDir() {
+			continue
+		}
+		rel, _ := filepath.Rel(root, match)
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readData decodes a JSON or YAML file.
+func readData(path string) (interface{}, error) {
+	out, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := yaml.Unmarshal([]byte(out), &data); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// generatorLabel is set on generated ConfigMaps and Secrets to the name
+	// of their generator, to find older versions to prune.
+	generatorLabel = "drone-kubernetes/generator"
+
+	// generatorReleaseLabel is set to the release the objects were generated
+	// for, so that pipelines sharing a generator name do not prune each
+	// other's objects.
+	generatorReleaseLabel = "drone-kubernetes/generator-release"
+)
+
+// generator builds a ConfigMap or a Secret from files, literals and env
+// files. Files are given as "path" or "key=path", literals as "key=value".
+type generator struct {
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Type      string   `json:"type"`
+	Files     []string `json:"files"`
+	Literals  []string `json:"literals"`
+	Envs      []string `json:"envs"`
+}
+
+// applyGenerators builds the configured ConfigMaps and Secrets, named after
+// a hash of their content, and points every reference to them in manifests
+// to the hashed name. Generated objects come first, so that they exist
+// before the workloads using them roll out.
+func (p Plugin) applyGenerators(manifests []manifest) ([]manifest, error) {
+	var generators []generator
+	if err := decodeSetting("generators", p.Config.Generators, &generators); err != nil {
+		return nil, err
+	}
+	if len(generators) == 0 {
+		return manifests, nil
+	}
+
+	var generated []manifest
+	renamed := map[string]string{}
+	for _, g := range generators {
+		m, err := g.generate(p.releaseName())
+		if err != nil {
+			log.Println("Error when generating " + g.Kind + " " + g.Name)
+			return nil, err
+		}
+		log.Println("Generated " + m.Kind + " " + m.Name)
+		generated = append(generated, m)
+		renamed[g.Kind+"/"+g.Name] = m.Name
+	}
+
+	err := transformManifests(manifests, func(m manifest, u map[string]interface{}) error {
+		renameReferences(u, renamed)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(generated, manifests...), nil
+}
+
+// generate builds the object for release, named after its base name and the
+// first 10 characters of the SHA-256 of its content.
+func (g generator) generate(release string) (manifest, error) {
+	if g.Name == "" {
+		return manifest{}, fmt.Errorf("generators must have a name")
+	}
+
+	data := map[string][]byte{}
+	for _, file := range g.Files {
+		key, path := filepath.Base(file), file
+		if i := strings.Index(file, "="); i >= 0 {
+			key, path = file[:i], file[i+1:]
+		}
+		out, err := ioutil.ReadFile(path)
+		if err != nil {
+			return manifest{}, err
+		}
+		data[key] = out
+	}
+	for _, env := range g.Envs {
+		out, err := ioutil.ReadFile(env)
+		if err != nil {
+			return manifest{}, err
+		}
+		if err := parseEnvFile(out, data); err != nil {
+			return manifest{}, fmt.Errorf("%s: %v", env, err)
+		}
+	}
+	for _, literal := range g.Literals {
+		parts := strings.SplitN(literal, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return manifest{}, fmt.Errorf("invalid literal %q, expected key=value", literal)
+		}
+		data[parts[0]] = []byte(parts[1])
+	}
+
+	content, err := json.Marshal(struct {
+		Kind string
+		Type string
+		Data map[string][]byte
+	}{g.Kind, g.Type, data})
+	if err != nil {
+		return manifest{}, err
+	}
+	objectMeta := metav1.ObjectMeta{
+		Name:      g.Name + "-" + hexDigest(content)[:10],
+		Namespace: g.Namespace,
+		Labels:    map[string]string{generatorLabel: g.Name, generatorReleaseLabel: release},
+	}
+
+	source := "generator " + g.Name
+	switch g.Kind {
+	case "ConfigMap":
+		configMap := &corev1.ConfigMap{ObjectMeta: objectMeta, Data: map[string]string{}}
+		for key, val := range data {
+			if utf8.Valid(val) {
+				configMap.Data[key] = string(val)
+				continue
+			}
+			if configMap.BinaryData == nil {
+				configMap.BinaryData = map[string][]byte{}
+			}
+			configMap.BinaryData[key] = val
+		}
+		configMap.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+		return newManifest(source, 1, 0, configMap), nil
+	case "Secret":
+		secret := &corev1.Secret{ObjectMeta: objectMeta, Type: corev1.SecretType(g.Type), Data: data}
+		if secret.Type == "" {
+			secret.Type = corev1.SecretTypeOpaque
+		}
+		secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+		return newManifest(source, 1, 0, secret), nil
+	}
+
+	return manifest{}, fmt.Errorf("generators can only build a ConfigMap or a Secret, not %q", g.Kind)
+}
+
+// parseEnvFile reads KEY=VALUE lines, skipping blank lines and comments.
+func parseEnvFile(content []byte, data map[string][]byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("line %d: expected KEY=VALUE", line)
+		}
+		data[strings.TrimSpace(parts[0])] = []byte(parts[1])
+	}
+	return scanner.Err()
+}
+
+// pruneGenerated deletes the older versions of the generated ConfigMaps and
+// Secrets of manifests.
+func (p Plugin) pruneGenerated(ctx context.Context, clientset *kubernetes.Clientset, manifests []manifest) error {
+	objects, err := p.prunableGenerated(ctx, clientset, manifests)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		name, namespace := accessor.GetName(), accessor.GetNamespace()
+		err = p.retry(ctx, "deleting "+kind+" "+name, func() error {
+			if kind == "ConfigMap" {
+				return clientset.CoreV1().ConfigMaps(namespace).Delete(name, &metav1.DeleteOptions{})
+			}
+			return clientset.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{})
+		})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			log.Println("Error when pruning " + kind + " " + name)
+			return err
+		}
+		log.Println(kind + " " + name + " pruned")
+	}
+	return nil
+}
+
+// prunableGenerated returns the live ConfigMaps and Secrets generated for
+// an older version of the generators of manifests.
+func (p Plugin) prunableGenerated(ctx context.Context, clientset *kubernetes.Clientset, manifests []manifest) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	for _, m := range manifests {
+		if m.Kind != "ConfigMap" && m.Kind != "Secret" {
+			continue
+		}
+		accessor, err := meta.Accessor(m.Object)
+		if err != nil {
+			return nil, err
+		}
+		labels := accessor.GetLabels()
+		base, ok := labels[generatorLabel]
+		if !ok {
+			continue
+		}
+
+		namespace := p.namespaceOf(m.Object)
+		selector := metav1.ListOptions{LabelSelector: generatorLabel + "=" + base + "," + generatorReleaseLabel + "=" + labels[generatorReleaseLabel]}
+		var items []runtime.Object
+		err = p.retry(ctx, "listing "+m.Kind+" "+base, func() error {
+			items = nil
+			if m.Kind == "ConfigMap" {
+				list, err := clientset.CoreV1().ConfigMaps(namespace).List(selector)
+				if err != nil {
+					return err
+				}
+				for i := range list.Items {
+					items = append(items, &list.Items[i])
+				}
+				return nil
+			}
+			list, err := clientset.CoreV1().Secrets(namespace).List(selector)
+			if err != nil {
+				return err
+			}
+			for i := range list.Items {
+				items = append(items, &list.Items[i])
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println("Error when listing generated " + m.Kind + " " + base)
+			return nil, err
+		}
+
+		for _, item := range items {
+			if itemAccessor, err := meta.Accessor(item); err == nil && itemAccessor.GetName() != m.Name {
+				// list items come without apiVersion and kind
+				item.GetObjectKind().SetGroupVersionKind(m.Object.GetObjectKind().GroupVersionKind())
+				objects = append(objects, item)
+			}
+		}
+	}
+	return objects, nil
+}
+
+// hexDigest returns the hex encoded SHA-256 of data.
+func hexDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
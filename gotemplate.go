@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// missingFunc is the function the printed values of non-strict templates
+// are piped to.
+const missingFunc = "_missingAsEmpty"
+
+// RenderGoTemplate parses and executes a text/template, returning the
+// trimmed result like RenderTrim. In strict mode a reference to a missing
+// map key fails the rendering, otherwise it renders as an empty string.
+// funcs are added to goFuncs for this template only.
+func RenderGoTemplate(name, source string, payload interface{}, strict bool, funcs template.FuncMap) (string, error) {
+	tmpl := template.New(name).Funcs(goFuncs).Funcs(funcs)
+	if strict {
+		tmpl = tmpl.Option("missingkey=error")
+	} else {
+		tmpl = tmpl.Funcs(template.FuncMap{missingFunc: missingAsEmpty})
+	}
+
+	tmpl, err := tmpl.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	if !strict {
+		for _, t := range tmpl.Templates() {
+			if t.Tree != nil {
+				pipeMissingAsEmpty(t.Tree.Root)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, payload); err != nil {
+		return "", err
+	}
+	return strings.Trim(out.String(), " \n"), nil
+}
+
+// missingAsEmpty returns an empty string for missing and nil values, which
+// text/template would print as "<no value>" or "<nil>".
+func missingAsEmpty(v interface{}) interface{} {
+	if v == nil {
+		return ""
+	}
+	return v
+}
+
+// pipeMissingAsEmpty appends missingFunc to the pipeline of every action
+// printing a value, so that missing values are handled while executing,
+// whatever the text around them.
+func pipeMissingAsEmpty(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			pipeMissingAsEmpty(child)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		identifier := parse.NewIdentifier(missingFunc).SetPos(n.Pos)
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: n.Pos, Args: []parse.Node{identifier}})
+	case *parse.IfNode:
+		pipeMissingAsEmpty(n.List)
+		pipeMissingAsEmpty(n.ElseList)
+	case *parse.RangeNode:
+		pipeMissingAsEmpty(n.List)
+		pipeMissingAsEmpty(n.ElseList)
+	case *parse.WithNode:
+		pipeMissingAsEmpty(n.List)
+		pipeMissingAsEmpty(n.ElseList)
+	}
+}
+
+// goFuncs follows the naming and argument order of the Sprig library, so
+// that the piped value always comes last.
+var goFuncs = template.FuncMap{
+	// defaults and flow
+	"default":  defaultValue,
+	"required": required,
+	"empty":    isEmpty,
+	"coalesce": coalesce,
+	"ternary":  ternary,
+	"fail":     fail,
+
+	// strings
+	"upper":          strings.ToUpper,
+	"lower":          strings.ToLower,
+	"title":          strings.Title,
+	"uppercasefirst": uppercaseFirst,
+	"trim":           strings.TrimSpace,
+	"trimAll":        func(cutset, s string) string { return strings.Trim(s, cutset) },
+	"trimPrefix":     func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix":     func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"trunc":          trunc,
+	"replace":        func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":       func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":      func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":      func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"repeat":         repeat,
+	"split":          func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":           join,
+	"quote":          quote,
+	"squote":         squote,
+	"indent":         indentAny,
+	"nindent":        nindent,
+	"urlencode":      url.QueryEscape,
+	"toString":       toString,
+	"atoi":           func(s string) (int, error) { return strconv.Atoi(strings.TrimSpace(s)) },
+
+	// regular expressions
+	"regexMatch":      func(regex, s string) (bool, error) { return regexp.MatchString(regex, s) },
+	"regexFind":       regexFind,
+	"regexReplace":    regexReplace,
+	"regexReplaceAll": regexReplace,
+
+	// encoding and hashing
+	"b64enc":    func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":    b64dec,
+	"sha1sum":   func(s string) string { return fmt.Sprintf("%x", sha1.Sum([]byte(s))) },
+	"sha256sum": func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) },
+	"toYaml":    toYaml,
+	"fromYaml":  fromYaml,
+	"toJson":    toJson,
+	"fromJson":  fromJson,
+
+	// lists and dictionaries
+	"list":   func(items ...interface{}) []interface{} { return items },
+	"dict":   dict,
+	"hasKey": func(d map[string]interface{}, key string) bool { _, ok := d[key]; return ok },
+	"keys":   keys,
+
+	// numbers
+	"add": arithmetic(func(a, b int64) int64 { return a + b }),
+	"sub": arithmetic(func(a, b int64) int64 { return a - b }),
+	"mul": arithmetic(func(a, b int64) int64 { return a * b }),
+	"div": div,
+	"mod": mod,
+
+	// dates
+	"now":      time.Now,
+	"date":     func(layout string, t time.Time) string { return t.Format(layout) },
+	"unixDate": func(layout string, ts int64) string { return time.Unix(ts, 0).UTC().Format(layout) },
+
+	// semantic versions
+	"semver":        parseSemver,
+	"semverCompare": semverMatches,
+}
+
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || isEmpty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+func required(msg string, val interface{}) (interface{}, error) {
+	if isEmpty(val) {
+		return nil, errors.New(msg)
+	}
+	return val, nil
+}
+
+func fail(msg string) (string, error) {
+	return "", errors.New(msg)
+}
+
+func isEmpty(val interface{}) bool {
+	v := reflect.ValueOf(val)
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		return reflect.DeepEqual(val, reflect.Zero(v.Type()).Interface())
+	}
+	return false
+}
+
+func coalesce(values ...interface{}) interface{} {
+	for _, val := range values {
+		if !isEmpty(val) {
+			return val
+		}
+	}
+	return nil
+}
+
+func ternary(yes, no interface{}, condition bool) interface{} {
+	if condition {
+		return yes
+	}
+	return no
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(val)
+}
+
+func join(sep string, list interface{}) string {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return toString(list)
+	}
+	items := make([]string, v.Len())
+	for i := range items {
+		items[i] = toString(v.Index(i).Interface())
+	}
+	return strings.Join(items, sep)
+}
+
+func quote(values ...interface{}) string {
+	out := make([]string, 0, len(values))
+	for _, val := range values {
+		if val != nil {
+			out = append(out, strconv.Quote(toString(val)))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func squote(values ...interface{}) string {
+	out := make([]string, 0, len(values))
+	for _, val := range values {
+		if val != nil {
+			out = append(out, "'"+toString(val)+"'")
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func indentAny(spaces interface{}, s string) (string, error) {
+	n, err := toInt64(spaces)
+	if err != nil {
+		return "", err
+	}
+	return indent(int(n), s), nil
+}
+
+func nindent(spaces interface{}, s string) (string, error) {
+	out, err := indentAny(spaces, s)
+	return "\n" + out, err
+}
+
+func trunc(length interface{}, s string) (string, error) {
+	n, err := toInt64(length)
+	if err != nil {
+		return "", err
+	}
+	return truncate(s, int(n)), nil
+}
+
+func repeat(count interface{}, s string) (string, error) {
+	n, err := toInt64(count)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", fmt.Errorf("repeat: negative count %d", n)
+	}
+	return strings.Repeat(s, int(n)), nil
+}
+
+// arithmetic turns an operation on integers into a template function taking
+// any number, as values decoded from JSON and YAML are float64.
+func arithmetic(op func(a, b int64) int64) func(a, b interface{}) (int64, error) {
+	return func(a, b interface{}) (int64, error) {
+		x, err := toInt64(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := toInt64(b)
+		if err != nil {
+			return 0, err
+		}
+		return op(x, y), nil
+	}
+}
+
+func div(a, b interface{}) (int64, error) {
+	if y, err := toInt64(b); err == nil && y == 0 {
+		return 0, errors.New("div: division by zero")
+	}
+	return arithmetic(func(a, b int64) int64 { return a / b })(a, b)
+}
+
+func mod(a, b interface{}) (int64, error) {
+	if y, err := toInt64(b); err == nil && y == 0 {
+		return 0, errors.New("mod: division by zero")
+	}
+	return arithmetic(func(a, b int64) int64 { return a % b })(a, b)
+}
+
+// toInt64 converts a number, or a string holding one, to an int64 like
+// Sprig does, truncating floats.
+func toInt64(v interface{}) (int64, error) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(value.Float()), nil
+	case reflect.String:
+		s := strings.TrimSpace(value.String())
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return int64(f), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot convert %v (%T) to an integer", v, v)
+}
+
+func regexFind(regex, s string) (string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+	return r.FindString(s), nil
+}
+
+func regexReplace(regex, s, repl string) (string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+	return r.ReplaceAllString(s, repl), nil
+}
+
+func b64dec(s string) (string, error) {
+	out, err := base64.StdEncoding.DecodeString(s)
+	return string(out), err
+}
+
+func toYaml(val interface{}) (string, error) {
+	out, err := yaml.Marshal(val)
+	return strings.TrimSuffix(string(out), "\n"), err
+}
+
+func fromYaml(s string) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	err := yaml.Unmarshal([]byte(s), &out)
+	return out, err
+}
+
+func toJson(val interface{}) (string, error) {
+	out, err := json.Marshal(val)
+	return string(out), err
+}
+
+func fromJson(s string) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	err := json.Unmarshal([]byte(s), &out)
+	return out, err
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict expects an even number of arguments")
+	}
+	d := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		d[toString(pairs[i])] = pairs[i+1]
+	}
+	return d, nil
+}
+
+func keys(d map[string]interface{}) []string {
+	out := make([]string, 0, len(d))
+	for k := range d {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
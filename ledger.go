@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// releaseLabel holds the release name on every release record.
+	releaseLabel = "drone-kubernetes/release"
+
+	// releaseRevisionLabel holds the revision of a release record.
+	releaseRevisionLabel = "drone-kubernetes/release-revision"
+
+	// releaseStatusLabel holds the outcome of a release record.
+	releaseStatusLabel = "drone-kubernetes/release-status"
+
+	// releaseSecretType is the type of release records stored as Secrets.
+	releaseSecretType = "drone-kubernetes/release"
+
+	releaseKey  = "release"
+	manifestKey = "manifest.gz"
+
+	// recordTimeout bounds the writing of a release record once the run
+	// context is done, so that interrupted runs are recorded too.
+	recordTimeout = 30 * time.Second
+)
+
+type (
+	// release is the record of a deploy, stored in the target namespace.
+	release struct {
+		Name     string          `json:"name"`
+		Revision int             `json:"revision"`
+		Status   string          `json:"status"`
+		Error    string          `json:"error,omitempty"`
+		Started  time.Time       `json:"started"`
+		Finished time.Time       `json:"finished"`
+		Repo     Repo            `json:"repo"`
+		Build    Build           `json:"build"`
+		Job      Job             `json:"job"`
+		Objects  []releaseObject `json:"objects"`
+
+		// Manifest is the rendered manifest, stored compressed next to the
+		// record.
+		Manifest []byte `json:"-"`
+	}
+
+	// releaseObject is an object of a release, with the resourceVersion it
+	// had once applied.
+	releaseObject struct {
+		Kind            string `json:"kind"`
+		Namespace       string `json:"namespace,omitempty"`
+		Name            string `json:"name"`
+		Applied         bool   `json:"applied"`
+		Skipped         bool   `json:"skipped,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	}
+)
+
+// releaseName returns the name release records are grouped by, the
+// repository name unless release_name is set.
+func (p Plugin) releaseName() string {
+	name := p.Config.ReleaseName
+	if name == "" {
+		name = p.Repo.Name
+	}
+	if name == "" {
+		name = "drone-kubernetes"
+	}
+	return dnsName(name)
+}
+
+// ledgerNamespace is the namespace release records are written to.
+func (p Plugin) ledgerNamespace() string {
+	if p.Config.NamespaceOverride != "" {
+		return p.Config.NamespaceOverride
+	}
+	return p.Config.Namespace
+}
+
+// recordRelease writes the record of a deploy, successful or not, then
+// removes the records beyond history_limit.
+func (p Plugin) recordRelease(ctx context.Context, manifests []manifest, applied int, started time.Time, deployErr error) error {
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), recordTimeout)
+		defer cancel()
+	}
+	// the client of the run is bound to its context, which may be done
+	clientset, err := p.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	releases, err := p.listReleases(ctx, clientset)
+	if err != nil {
+		return err
+	}
+
+	r := release{
+		Name:     p.releaseName(),
+		Revision: 1,
+		Status:   "deployed",
+		Started:  started.UTC(),
+		Finished: time.Now().UTC(),
+		Repo:     p.Repo,
+		Build:    p.Build,
+		Job:      p.Job,
+	}
+	if len(releases) > 0 {
+		r.Revision = releases[len(releases)-1].Revision + 1
+	}
+	if deployErr != nil {
+		r.Status, r.Error = "failed", deployErr.Error()
+	}
+
+	for i, m := range manifests {
+		object := releaseObject{Kind: m.Kind, Name: m.Name, Applied: appliedObject(i, m, applied, deployErr), Skipped: !isSupported(m.Object)}
+		if isNamespaced(m.Kind) {
+			object.Namespace = p.namespaceOf(m.Object)
+		}
+		if object.Applied {
+			var live runtime.Object
+			err := p.retry(ctx, "get "+m.String(), func() error {
+				var err error
+				live, err = p.getLive(clientset, m.Object)
+				return err
+			})
+			if accessor, accessorErr := meta.Accessor(live); err == nil && accessorErr == nil {
+				object.ResourceVersion = accessor.GetResourceVersion()
+			}
+		}
+		r.Objects = append(r.Objects, object)
+	}
+
+	stored := manifests
+	if p.Config.ReleaseLedgerKind == "ConfigMap" {
+		// ConfigMaps are readable more widely than Secrets
+		if stored, err = withoutSecretData(manifests); err != nil {
+			return err
+		}
+	}
+	if r.Manifest, err = marshalManifests(stored); err != nil {
+		return err
+	}
+	if err := p.writeRelease(ctx, clientset, &r); err != nil {
+		return err
+	}
+	log.Printf("Recorded release %s revision %d (%s)", r.Name, r.Revision, r.Status)
+
+	releases = append(releases, r)
+	if limit := p.Config.HistoryLimit; limit > 0 && len(releases) > limit {
+		for _, old := range releases[:len(releases)-limit] {
+			if err := p.deleteRelease(ctx, clientset, old); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReleaseLedgerKind fails on an unknown release_ledger_kind.
+func (p Plugin) checkReleaseLedgerKind() error {
+	if p.Config.ReleaseLedgerKind != "Secret" && p.Config.ReleaseLedgerKind != "ConfigMap" {
+		return fmt.Errorf("unknown release_ledger_kind %s, expected Secret or ConfigMap", p.Config.ReleaseLedgerKind)
+	}
+	return nil
+}
+
+// listReleases returns the records of the release, sorted by revision.
+func (p Plugin) listReleases(ctx context.Context, clientset *kubernetes.Clientset) ([]release, error) {
+	if err := p.checkReleaseLedgerKind(); err != nil {
+		return nil, err
+	}
+	namespace := p.ledgerNamespace()
+	options := metav1.ListOptions{LabelSelector: releaseLabel + "=" + p.releaseName()}
+
+	var releases []release
+	err := p.retry(ctx, "list releases", func() error {
+		releases = nil
+		var records []map[string][]byte
+		if p.Config.ReleaseLedgerKind == "ConfigMap" {
+			list, err := clientset.CoreV1().ConfigMaps(namespace).List(options)
+			if err != nil {
+				return err
+			}
+			for _, cm := range list.Items {
+				records = append(records, map[string][]byte{releaseKey: []byte(cm.Data[releaseKey]), manifestKey: cm.BinaryData[manifestKey]})
+			}
+		} else {
+			list, err := clientset.CoreV1().Secrets(namespace).List(options)
+			if err != nil {
+				return err
+			}
+			for _, secret := range list.Items {
+				records = append(records, secret.Data)
+			}
+		}
+
+		for _, record := range records {
+			var r release
+			if err := json.Unmarshal(record[releaseKey], &r); err != nil {
+				log.Println("Error when decoding a release record")
+				return err
+			}
+			r.Manifest = record[manifestKey]
+			releases = append(releases, r)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error when listing the releases of " + p.releaseName())
+		return nil, err
+	}
+
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Revision < releases[j].Revision })
+	return releases, nil
+}
+
+// writeRelease creates the ConfigMap or Secret holding a release record. When
+// a concurrent deploy of the same release took the revision first, the
+// releases are listed again and the record takes the next revision.
+func (p Plugin) writeRelease(ctx context.Context, clientset *kubernetes.Clientset, r *release) error {
+	return p.retry(ctx, "record release", func() error {
+		err := p.createRelease(clientset, *r)
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		releases, listErr := p.listReleases(ctx, clientset)
+		if listErr != nil {
+			return listErr
+		}
+		if len(releases) > 0 {
+			r.Revision = releases[len(releases)-1].Revision + 1
+		}
+		return err
+	})
+}
+
+func (p Plugin) createRelease(clientset *kubernetes.Clientset, r release) error {
+	record, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	var manifest bytes.Buffer
+	gz := gzip.NewWriter(&manifest)
+	if _, err := gz.Write(r.Manifest); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	objectMeta := metav1.ObjectMeta{
+		Name:      releaseRecordName(r),
+		Namespace: p.ledgerNamespace(),
+		Labels: map[string]string{
+			releaseLabel:         r.Name,
+			releaseRevisionLabel: strconv.Itoa(r.Revision),
+			releaseStatusLabel:   r.Status,
+		},
+	}
+	if p.Config.ReleaseLedgerKind == "ConfigMap" {
+		_, err := clientset.CoreV1().ConfigMaps(objectMeta.Namespace).Create(&corev1.ConfigMap{
+			ObjectMeta: objectMeta,
+			Data:       map[string]string{releaseKey: string(record)},
+			BinaryData: map[string][]byte{manifestKey: manifest.Bytes()},
+		})
+		return err
+	}
+	_, err = clientset.CoreV1().Secrets(objectMeta.Namespace).Create(&corev1.Secret{
+		ObjectMeta: objectMeta,
+		Type:       releaseSecretType,
+		Data:       map[string][]byte{releaseKey: record, manifestKey: manifest.Bytes()},
+	})
+	return err
+}
+
+func (p Plugin) deleteRelease(ctx context.Context, clientset *kubernetes.Clientset, r release) error {
+	name := releaseRecordName(r)
+	namespace := p.ledgerNamespace()
+	err := p.retry(ctx, "delete release "+name, func() error {
+		var err error
+		if p.Config.ReleaseLedgerKind == "ConfigMap" {
+			err = clientset.CoreV1().ConfigMaps(namespace).Delete(name, &metav1.DeleteOptions{})
+		} else {
+			err = clientset.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{})
+		}
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Println("Error when deleting release record " + name)
+	}
+	return err
+}
+
+func releaseRecordName(r release) string {
+	return fmt.Sprintf("%s.v%d", r.Name, r.Revision)
+}
+
+// history prints the recorded releases.
+func (p Plugin) history(ctx context.Context) error {
+	p.checkCluster()
+
+	clientset, err := p.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	releases, err := p.listReleases(ctx, clientset)
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		log.Println("No release recorded for " + p.releaseName() + " in namespace " + p.ledgerNamespace())
+		return nil
+	}
+
+	log.Println("Releases of " + p.releaseName() + ":")
+	log.Printf("%-9s %-8s %-9s %-9s %-20s %-8s %s", "REVISION", "BUILD", "COMMIT", "STATUS", "FINISHED", "OBJECTS", "ERROR")
+	for _, r := range releases {
+		applied := 0
+		for _, object := range r.Objects {
+			if object.Applied {
+				applied++
+			}
+		}
+		log.Printf("%-9d %-8d %-9s %-9s %-20s %-8s %s", r.Revision, r.Build.Number, truncate(r.Build.Commit, 8), r.Status,
+			r.Finished.Format("2006-01-02 15:04:05"), fmt.Sprintf("%d/%d", applied, len(r.Objects)), r.Error)
+	}
+	return nil
+}
+
+var invalidDNSChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// dnsName turns s into a valid DNS label, as object names require.
+func dnsName(s string) string {
+	s = invalidDNSChars.ReplaceAllString(strings.ToLower(s), "-")
+	if len(s) > 50 {
+		s = s[:50]
+	}
+	return strings.Trim(s, "-")
+}
+
+// withoutSecretData returns a copy of manifests where Secrets have neither
+// data nor stringData.
+func withoutSecretData(manifests []manifest) ([]manifest, error) {
+	stored := make([]manifest, len(manifests))
+	copy(stored, manifests)
+	err := transformManifests(stored, func(m manifest, u map[string]interface{}) error {
+		if m.Kind == "Secret" {
+			delete(u, "data")
+			delete(u, "stringData")
+		}
+		return nil
+	})
+	return stored, err
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// lookupKinds maps the kinds that can be read by kind and name only, such as
+// in template lookups, to the API version they are read with.
+var lookupKinds = map[string]func() runtime.Object{
+	"ConfigMap":             func() runtime.Object { return &corev1.ConfigMap{} },
+	"PersistentVolume":      func() runtime.Object { return &corev1.PersistentVolume{} },
+	"PersistentVolumeClaim": func() runtime.Object { return &corev1.PersistentVolumeClaim{} },
+	"Pod":                   func() runtime.Object { return &corev1.Pod{} },
+	"ReplicationController": func() runtime.Object { return &corev1.ReplicationController{} },
+	"Secret":                func() runtime.Object { return &corev1.Secret{} },
+	"Service":               func() runtime.Object { return &corev1.Service{} },
+	"DaemonSet":             func() runtime.Object { return &appsv1.DaemonSet{} },
+	"Deployment":            func() runtime.Object { return &appsv1.Deployment{} },
+	"ReplicaSet":            func() runtime.Object { return &appsv1.ReplicaSet{} },
+	"StatefulSet":           func() runtime.Object { return &appsv1.StatefulSet{} },
+	"Ingress":               func() runtime.Object { return &extensionsv1beta1.Ingress{} },
+}
+
+// isSupported reports whether obj is of a kind the plugin applies. Objects of
+// other kinds are skipped.
+func isSupported(obj runtime.Object) bool {
+	switch obj.(type) {
+	case *appsv1.DaemonSet, *appsv1.Deployment, *appsv1.ReplicaSet, *appsv1.StatefulSet,
+		*appsv1beta1.Deployment, *appsv1beta1.StatefulSet,
+		*appsv1beta2.DaemonSet, *appsv1beta2.Deployment, *appsv1beta2.ReplicaSet, *appsv1beta2.StatefulSet,
+		*corev1.ConfigMap, *corev1.PersistentVolume, *corev1.PersistentVolumeClaim, *corev1.Pod,
+		*corev1.ReplicationController, *corev1.Secret, *corev1.Service,
+		*extensionsv1beta1.DaemonSet, *extensionsv1beta1.Deployment, *extensionsv1beta1.Ingress, *extensionsv1beta1.ReplicaSet:
+		return true
+	}
+	return false
+}
+
+// getLive fetches the live version of obj from the cluster, with the client
+// matching its API group and version.
+func (p Plugin) getLive(clientset *kubernetes.Clientset, obj runtime.Object) (runtime.Object, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	name := accessor.GetName()
+	namespace := p.namespaceOf(obj)
+	options := metav1.GetOptions{}
+
+	var live runtime.Object
+	switch obj.(type) {
+	// appsv1
+	case *appsv1.DaemonSet:
+		live, err = clientset.AppsV1().DaemonSets(namespace).Get(name, options)
+	case *appsv1.Deployment:
+		live, err = clientset.AppsV1().Deployments(namespace).Get(name, options)
+	case *appsv1.ReplicaSet:
+		live, err = clientset.AppsV1().ReplicaSets(namespace).Get(name, options)
+	case *appsv1.StatefulSet:
+		live, err = clientset.AppsV1().StatefulSets(namespace).Get(name, options)
+
+	// appsv1beta1
+	case *appsv1beta1.Deployment:
+		live, err = clientset.AppsV1beta1().Deployments(namespace).Get(name, options)
+	case *appsv1beta1.StatefulSet:
+		live, err = clientset.AppsV1beta1().StatefulSets(namespace).Get(name, options)
+
+	// appsv1beta2
+	case *appsv1beta2.DaemonSet:
+		live, err = clientset.AppsV1beta2().DaemonSets(namespace).Get(name, options)
+	case *appsv1beta2.Deployment:
+		live, err = clientset.AppsV1beta2().Deployments(namespace).Get(name, options)
+	case *appsv1beta2.ReplicaSet:
+		live, err = clientset.AppsV1beta2().ReplicaSets(namespace).Get(name, options)
+	case *appsv1beta2.StatefulSet:
+		live, err = clientset.AppsV1beta2().StatefulSets(namespace).Get(name, options)
+
+	// corev1
+	case *corev1.ConfigMap:
+		live, err = clientset.CoreV1().ConfigMaps(namespace).Get(name, options)
+	case *corev1.PersistentVolume:
+		live, err = clientset.CoreV1().PersistentVolumes().Get(name, options)
+	case *corev1.PersistentVolumeClaim:
+		live, err = clientset.CoreV1().PersistentVolumeClaims(namespace).Get(name, options)
+	case *corev1.Pod:
+		live, err = clientset.CoreV1().Pods(namespace).Get(name, options)
+	case *corev1.ReplicationController:
+		live, err = clientset.CoreV1().ReplicationControllers(namespace).Get(name, options)
+	case *corev1.Secret:
+		live, err = clientset.CoreV1().Secrets(namespace).Get(name, options)
+	case *corev1.Service:
+		live, err = clientset.CoreV1().Services(namespace).Get(name, options)
+
+	// extensionsv1beta1
+	case *extensionsv1beta1.DaemonSet:
+		live, err = clientset.ExtensionsV1beta1().DaemonSets(namespace).Get(name, options)
+	case *extensionsv1beta1.Deployment:
+		live, err = clientset.ExtensionsV1beta1().Deployments(namespace).Get(name, options)
+	case *extensionsv1beta1.Ingress:
+		live, err = clientset.ExtensionsV1beta1().Ingresses(namespace).Get(name, options)
+	case *extensionsv1beta1.ReplicaSet:
+		live, err = clientset.ExtensionsV1beta1().ReplicaSets(namespace).Get(name, options)
+
+	default:
+		return nil, fmt.Errorf("unsupported object %T", obj)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// objects returned by the typed clients come without apiVersion and kind
+	live.GetObjectKind().SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	return live, nil
+}
+
+// newLiveObject returns an empty object of kind, in the version listed in
+// lookupKinds, with its name and namespace set, to be read with getLive.
+func newLiveObject(kind, namespace, name string) (runtime.Object, error) {
+	newObject, ok := lookupKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported kind %s", kind)
+	}
+
+	obj := newObject()
+	gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+	if err != nil {
+		return nil, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+	objectMeta := obj.(metav1.Object)
+	objectMeta.SetName(name)
+	objectMeta.SetNamespace(namespace)
+	return obj, nil
+}
+
+// deleteLive deletes obj from the cluster, with the client matching its API
+// group and version. Dependents, such as the Pods of a Deployment, are
+// deleted in the background.
+func (p Plugin) deleteLive(clientset *kubernetes.Clientset, obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	name := accessor.GetName()
+	namespace := p.namespaceOf(obj)
+	propagation := metav1.DeletePropagationBackground
+	options := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	switch obj.(type) {
+	// appsv1
+	case *appsv1.DaemonSet:
+		return clientset.AppsV1().DaemonSets(namespace).Delete(name, options)
+	case *appsv1.Deployment:
+		return clientset.AppsV1().Deployments(namespace).Delete(name, options)
+	case *appsv1.ReplicaSet:
+		return clientset.AppsV1().ReplicaSets(namespace).Delete(name, options)
+	case *appsv1.StatefulSet:
+		return clientset.AppsV1().StatefulSets(namespace).Delete(name, options)
+
+	// appsv1beta1
+	case *appsv1beta1.Deployment:
+		return clientset.AppsV1beta1().Deployments(namespace).Delete(name, options)
+	case *appsv1beta1.StatefulSet:
+		return clientset.AppsV1beta1().StatefulSets(namespace).Delete(name, options)
+
+	// appsv1beta2
+	case *appsv1beta2.DaemonSet:
+		return clientset.AppsV1beta2().DaemonSets(namespace).Delete(name, options)
+	case *appsv1beta2.Deployment:
+		return clientset.AppsV1beta2().Deployments(namespace).Delete(name, options)
+	case *appsv1beta2.ReplicaSet:
+		return clientset.AppsV1beta2().ReplicaSets(namespace).Delete(name, options)
+	case *appsv1beta2.StatefulSet:
+		return clientset.AppsV1beta2().StatefulSets(namespace).Delete(name, options)
+
+	// corev1
+	case *corev1.ConfigMap:
+		return clientset.CoreV1().ConfigMaps(namespace).Delete(name, options)
+	case *corev1.PersistentVolume:
+		return clientset.CoreV1().PersistentVolumes().Delete(name, options)
+	case *corev1.PersistentVolumeClaim:
+		return clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(name, options)
+	case *corev1.Pod:
+		return clientset.CoreV1().Pods(namespace).Delete(name, options)
+	case *corev1.ReplicationController:
+		return clientset.CoreV1().ReplicationControllers(namespace).Delete(name, options)
+	case *corev1.Secret:
+		return clientset.CoreV1().Secrets(namespace).Delete(name, options)
+	case *corev1.Service:
+		return clientset.CoreV1().Services(namespace).Delete(name, options)
+
+	// extensionsv1beta1
+	case *extensionsv1beta1.DaemonSet:
+		return clientset.ExtensionsV1beta1().DaemonSets(namespace).Delete(name, options)
+	case *extensionsv1beta1.Deployment:
+		return clientset.ExtensionsV1beta1().Deployments(namespace).Delete(name, options)
+	case *extensionsv1beta1.Ingress:
+		return clientset.ExtensionsV1beta1().Ingresses(namespace).Delete(name, options)
+	case *extensionsv1beta1.ReplicaSet:
+		return clientset.ExtensionsV1beta1().ReplicaSets(namespace).Delete(name, options)
+	}
+	return fmt.Errorf("unsupported object %T", obj)
+}
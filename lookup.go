@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// lookup returns the live object of the given kind, namespace (empty for the
+// plugin namespace) and name, as a map, so that templates can read any of
+// its fields. A missing object, or any object when lookups are disabled or
+// no cluster is configured, is an empty map.
+func (p Plugin) lookup(ctx context.Context, kind, namespace, name string) (map[string]interface{}, error) {
+	obj, err := newLiveObject(kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if p.Config.LookupOffline || p.clientset == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	var live runtime.Object
+	err = p.retry(ctx, "lookup "+kind+" "+name, func() error {
+		var err error
+		live, err = p.getLive(p.clientset, obj)
+		return err
+	})
+	if errors.IsNotFound(err) {
+		return map[string]interface{}{}, nil
+	}
+	if err != nil {
+		log.Println("Error when looking up " + kind + " " + name)
+		return nil, err
+	}
+	return toUnstructured(live)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// scale sets the replicas of live workloads through their scale subresource.
+func (p Plugin) scale(ctx context.Context) error {
+	p.checkCluster()
+	if p.Config.Replicas < 0 {
+		log.Fatal("REPLICAS is not defined")
+	}
+
+	return p.patchWorkloads(ctx, []string{"Deployment", "StatefulSet", "ReplicaSet"}, func(clientset *kubernetes.Clientset, w workload) error {
+		log.Printf("Scaling %s to %d replicas", w, p.Config.Replicas)
+		patch := map[string]interface{}{"spec": map[string]interface{}{"replicas": p.Config.Replicas}}
+		return p.patchWorkload(ctx, clientset, w, types.MergePatchType, patch, "scale")
+	})
+}
+
+// pause pauses or resumes the rollouts of live Deployments.
+func (p Plugin) pause(ctx context.Context, paused bool) error {
+	p.checkCluster()
+
+	return p.patchWorkloads(ctx, []string{"Deployment"}, func(clientset *kubernetes.Clientset, w workload) error {
+		if paused {
+			log.Println("Pausing " + w.String())
+		} else {
+			log.Println("Resuming " + w.String())
+		}
+		patch := map[string]interface{}{"spec": map[string]interface{}{"paused": paused}}
+		return p.patchWorkload(ctx, clientset, w, types.StrategicMergePatchType, patch)
+	})
+}
+
+// restart triggers a rolling restart of live workloads, as kubectl rollout
+// restart does.
+func (p Plugin) restart(ctx context.Context) error {
+	p.checkCluster()
+
+	annotations := map[string]interface{}{
+		restartedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+		restartedByAnnotation: strconv.Itoa(p.Build.Number),
+	}
+	return p.patchWorkloads(ctx, []string{"Deployment", "StatefulSet", "DaemonSet"}, func(clientset *kubernetes.Clientset, w workload) error {
+		log.Println("Restarting " + w.String())
+		patch := map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": annotations},
+		}}}
+		return p.patchWorkload(ctx, clientset, w, types.StrategicMergePatchType, patch)
+	})
+}
+
+// patchWorkloads calls patch for every target, then waits for the workloads
+// to reach their new steady state when wait is set, unless they were just
+// paused.
+func (p Plugin) patchWorkloads(ctx context.Context, kinds []string, patch func(clientset *kubernetes.Clientset, w workload) error) error {
+	clientset, err := p.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	workloads, err := p.resolveWorkloads(ctx, clientset, kinds...)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range workloads {
+		if err := patch(clientset, w); err != nil {
+			return err
+		}
+	}
+
+	if p.Config.Wait && p.Config.Action != "pause" {
+		return p.waitForWorkloads(ctx, clientset, workloads)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// renderedTemplate is the output of a single template file, ready to be
+// decoded.
+type renderedTemplate struct {
+	Source  string
+	Content string
+}
+
+// manifest is a single object decoded from a rendered template, along with
+// the file and the document (starting at 1, empty documents are not counted)
+// it comes from. Item is set, also starting at 1, for objects expanded from a
+// List.
+type manifest struct {
+	Source string
+	Index  int
+	Item   int
+	Kind   string
+	Name   string
+	Object runtime.Object
+}
+
+func (m manifest) String() string {
+	if m.Item > 0 {
+		return fmt.Sprintf("%s %s (%s, document %d, item %d)", m.Kind, m.Name, m.Source, m.Index, m.Item)
+	}
+	return fmt.Sprintf("%s %s (%s, document %d)", m.Kind, m.Name, m.Source, m.Index)
+}
+
+// decodeManifests decodes every document of a rendered template. Documents
+// are only split on "---" lines, empty and comment-only documents are
+// skipped, and List objects are expanded into their items.
+func decodeManifests(template renderedTemplate) ([]manifest, error) {
+	docs, err := splitDocuments(template.Content)
+	if err != nil {
+		log.Println("Error when reading template " + template.Source)
+		return nil, err
+	}
+
+	var manifests []manifest
+	for i, doc := range docs {
+		obj, err := decodeObject(doc)
+		if err != nil {
+			log.Printf("Error when decoding template YAML (%s, document %d)", template.Source, i+1)
+			return nil, err
+		}
+
+		if !meta.IsListType(obj) {
+			manifests = append(manifests, newManifest(template.Source, i+1, 0, obj))
+			continue
+		}
+
+		items, err := meta.ExtractList(obj)
+		if err != nil {
+			log.Printf("Error when reading list items (%s, document %d)", template.Source, i+1)
+			return nil, err
+		}
+		for j, item := range items {
+			if unknown, ok := item.(*runtime.Unknown); ok {
+				item, err = decodeObject(unknown.Raw)
+				if err != nil {
+					log.Printf("Error when decoding list item (%s, document %d, item %d)", template.Source, i+1, j+1)
+					return nil, err
+				}
+			}
+			manifests = append(manifests, newManifest(template.Source, i+1, j+1, item))
+		}
+	}
+
+	return manifests, nil
+}
+
+func newManifest(source string, index, item int, obj runtime.Object) manifest {
+	m := manifest{Source: source, Index: index, Item: item, Object: obj}
+	if kinds, _, err := scheme.Scheme.ObjectKinds(obj); err == nil && len(kinds) > 0 {
+		// items of typed lists come without apiVersion and kind
+		obj.GetObjectKind().SetGroupVersionKind(kinds[0])
+		m.Kind = kinds[0].Kind
+	}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		m.Name = accessor.GetName()
+	}
+	return m
+}
+
+// splitDocuments returns every non-empty document of a YAML stream or a JSON
+// stream, converted to JSON.
+func splitDocuments(content string) ([][]byte, error) {
+	reader, _, isJSON := yaml.GuessJSONStream(strings.NewReader(content), 4096)
+
+	var docs [][]byte
+	if isJSON {
+		decoder := json.NewDecoder(reader)
+		for {
+			var doc json.RawMessage
+			if err := decoder.Decode(&doc); err == io.EOF {
+				return docs, nil
+			} else if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+	}
+
+	yamlReader := yaml.NewYAMLReader(bufio.NewReader(reader))
+	for {
+		doc, err := yamlReader.Read()
+		if err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		data, err := yaml.ToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+		// comment-only and empty documents convert to null
+		if data = bytes.TrimSpace(data); len(data) == 0 || bytes.Equal(data, []byte("null")) {
+			continue
+		}
+		docs = append(docs, data)
+	}
+}
+
+func decodeObject(data []byte) (runtime.Object, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	return obj, err
+}
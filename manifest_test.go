@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "single document",
+			content: "a: 1\n",
+			want:    []string{`{"a":1}`},
+		},
+		{
+			name:    "separators",
+			content: "---\na: 1\n---\nb: 2\n...\n",
+			want:    []string{`{"a":1}`, `{"b":2}`},
+		},
+		{
+			name:    "separator with trailing spaces",
+			content: "a: 1\n---   \nb: 2\n",
+			want:    []string{`{"a":1}`, `{"b":2}`},
+		},
+		{
+			name:    "empty and comment-only documents are skipped",
+			content: "---\n---\n# only a comment\n---\n\n   \n---\na: 1\n---\n# trailing comment\n",
+			want:    []string{`{"a":1}`},
+		},
+		{
+			name:    "only comments",
+			content: "# nothing to deploy\n",
+			want:    nil,
+		},
+		{
+			name:    "--- inside a block scalar",
+			content: "data:\n  script: |\n    echo start\n    ---\n    echo end\nb: 2\n",
+			want:    []string{`{"b":2,"data":{"script":"echo start\n---\necho end\n"}}`},
+		},
+		{
+			name:    "--- inside a folded scalar",
+			content: "data:\n  text: >\n    one\n    --- two\n",
+			want:    []string{`{"data":{"text":"one --- two\n"}}`},
+		},
+		{
+			name:    "--- followed by text is not a separator",
+			content: "a: |\n  x\n---b: 1\n",
+			want:    []string{`{"---b":1,"a":"x\n"}`},
+		},
+		{
+			name:    "JSON stream",
+			content: `{"a":1} {"b":2}`,
+			want:    []string{`{"a":1}`, `{"b":2}`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docs, err := splitDocuments(test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, doc := range docs {
+				got = append(got, string(doc))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeManifests(t *testing.T) {
+	content := `
+# leading comment only
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: first
+data:
+  script: |
+    ---
+---
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: web
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: web
+`
+	manifests, err := decodeManifests(renderedTemplate{Source: "app.yml", Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, m := range manifests {
+		got = append(got, m.String())
+	}
+	want := []string{
+		"ConfigMap first (app.yml, document 1)",
+		"Service web (app.yml, document 2, item 1)",
+		"Deployment web (app.yml, document 2, item 2)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+type (
+	// patchTarget selects the documents a patch applies to. Empty fields
+	// match anything.
+	patchTarget struct {
+		Kind      string `json:"kind"`
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	}
+
+	// jsonPatch is a JSON6902 patch file, or inline operations, with its
+	// target.
+	jsonPatch struct {
+		Target     patchTarget          `json:"target"`
+		Path       string               `json:"path"`
+		Operations []jsonPatchOperation `json:"patch"`
+	}
+
+	// imageOverride replaces the name, the tag or the digest of every
+	// container image named Name.
+	imageOverride struct {
+		Name    string `json:"name"`
+		NewName string `json:"new_name"`
+		NewTag  string `json:"new_tag"`
+		Digest  string `json:"digest"`
+	}
+
+	// strategicPatch is a strategic merge patch, targeting the document of
+	// the same kind, name and namespace.
+	strategicPatch struct {
+		Source string
+		Target patchTarget
+		Patch  map[string]interface{}
+	}
+)
+
+// applyOverlays transforms the rendered manifests, in this order: strategic
+// merge patches, JSON6902 patches, image overrides, namespace override, name
+// prefix and suffix, common labels and common annotations. Patches target
+// documents by their names before any renaming.
+func (p Plugin) applyOverlays(manifests []manifest) ([]manifest, error) {
+	strategicPatches, err := p.loadStrategicPatches()
+	if err != nil {
+		return nil, err
+	}
+
+	var jsonPatches []jsonPatch
+	if err := decodeSetting("json_patches", p.Config.JSONPatches, &jsonPatches); err != nil {
+		return nil, err
+	}
+	for i, patch := range jsonPatches {
+		if patch.Path == "" {
+			continue
+		}
+		out, err := ioutil.ReadFile(patch.Path)
+		if err != nil {
+			log.Println("Error when reading JSON patch " + patch.Path)
+			return nil, err
+		}
+		data, err := yaml.ToJSON(out)
+		if err == nil {
+			err = json.Unmarshal(data, &jsonPatches[i].Operations)
+		}
+		if err != nil {
+			log.Println("Error when decoding JSON patch " + patch.Path)
+			return nil, err
+		}
+	}
+
+	var images []imageOverride
+	if err := decodeSetting("images", p.Config.Images, &images); err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	if err := decodeSetting("common_labels", p.Config.CommonLabels, &labels); err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{}
+	if err := decodeSetting("common_annotations", p.Config.CommonAnnotations, &annotations); err != nil {
+		return nil, err
+	}
+
+	if len(strategicPatches) == 0 && len(jsonPatches) == 0 && len(images) == 0 && len(labels) == 0 &&
+		len(annotations) == 0 && p.Config.NamespaceOverride == "" && p.Config.NamePrefix == "" && p.Config.NameSuffix == "" {
+		return manifests, nil
+	}
+
+	objects := make([]map[string]interface{}, len(manifests))
+	for i, m := range manifests {
+		if objects[i], err = toUnstructured(m.Object); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, patch := range strategicPatches {
+		matched := false
+		for i, m := range manifests {
+			if p.targets(patch.Target, m) {
+				objects[i] = strategicMerge(objects[i], deepCopyJSON(patch.Patch).(map[string]interface{}))
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("patch %s does not match any document", patch.Source)
+		}
+	}
+
+	for _, patch := range jsonPatches {
+		matched := false
+		for i, m := range manifests {
+			if !p.targets(patch.Target, m) {
+				continue
+			}
+			doc, err := applyJSONPatch(objects[i], patch.Operations)
+			if err != nil {
+				log.Println("Error when applying JSON patch to " + m.String())
+				return nil, err
+			}
+			objects[i], matched = doc.(map[string]interface{}), true
+		}
+		if !matched {
+			return nil, fmt.Errorf("JSON patch for %s %s does not match any document", patch.Target.Kind, patch.Target.Name)
+		}
+	}
+
+	renamed := map[string]string{}
+	for i, m := range manifests {
+		u := objects[i]
+		if template := podTemplate(u); template != nil {
+			for _, container := range containers(template) {
+				image, _ := container["image"].(string)
+				container["image"] = overrideImage(image, images)
+			}
+			setStringMapEntries(template, labels, "metadata", "labels")
+			setStringMapEntries(template, annotations, "metadata", "annotations")
+		}
+
+		if p.Config.NamespaceOverride != "" && isNamespaced(m.Kind) {
+			unstructured.SetNestedField(u, p.Config.NamespaceOverride, "metadata", "namespace")
+		}
+		if p.Config.NamePrefix != "" || p.Config.NameSuffix != "" {
+			name := p.Config.NamePrefix + m.Name + p.Config.NameSuffix
+			unstructured.SetNestedField(u, name, "metadata", "name")
+			renamed[m.Kind+"/"+m.Name] = name
+		}
+		setStringMapEntries(u, labels, "metadata", "labels")
+		setStringMapEntries(u, annotations, "metadata", "annotations")
+	}
+
+	for i := range manifests {
+		renameReferences(objects[i], renamed)
+		obj, err := fromUnstructured(objects[i])
+		if err != nil {
+			log.Println("Error when decoding transformed " + manifests[i].String())
+			return nil, err
+		}
+		manifests[i] = newManifest(manifests[i].Source, manifests[i].Index, manifests[i].Item, obj)
+	}
+
+	return manifests, nil
+}
+
+// loadStrategicPatches reads every document of the configured strategic
+// merge patch files.
+func (p Plugin) loadStrategicPatches() ([]strategicPatch, error) {
+	var patches []strategicPatch
+	for _, file := range p.Config.Patches {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Println("Error when reading patch " + file)
+			return nil, err
+		}
+		docs, err := splitDocuments(string(out))
+		if err != nil {
+			log.Println("Error when decoding patch " + file)
+			return nil, err
+		}
+
+		for i, doc := range docs {
+			patch := map[string]interface{}{}
+			if err := json.Unmarshal(doc, &patch); err != nil {
+				log.Println("Error when decoding patch " + file)
+				return nil, err
+			}
+			target := patchTarget{}
+			target.Kind, _, _ = unstructured.NestedString(patch, "kind")
+			target.Name, _, _ = unstructured.NestedString(patch, "metadata", "name")
+			target.Namespace, _, _ = unstructured.NestedString(patch, "metadata", "namespace")
+			if target.Kind == "" || target.Name == "" {
+				return nil, fmt.Errorf("patch %s (document %d) must set kind and metadata.name", file, i+1)
+			}
+			patches = append(patches, strategicPatch{
+				Source: fmt.Sprintf("%s (document %d)", file, i+1),
+				Target: target,
+				Patch:  patch,
+			})
+		}
+	}
+	return patches, nil
+}
+
+// targets reports whether a patch target selects m.
+func (p Plugin) targets(target patchTarget, m manifest) bool {
+	return (target.Kind == "" || target.Kind == m.Kind) &&
+		(target.Name == "" || target.Name == m.Name) &&
+		(target.Namespace == "" || target.Namespace == p.namespaceOf(m.Object))
+}
+
+// overrideImage applies the first matching image override to image.
+func overrideImage(image string, overrides []imageOverride) string {
+	name, tag, digest := splitImage(image)
+	for _, o := range overrides {
+		if o.Name != name {
+			continue
+		}
+		if o.NewName != "" {
+			name = o.NewName
+		}
+		if o.NewTag != "" {
+			tag, digest = o.NewTag, ""
+		}
+		if o.Digest != "" {
+			tag, digest = "", o.Digest
+		}
+		break
+	}
+
+	switch {
+	case digest != "":
+		return name + "@" + digest
+	case tag != "":
+		return name + ":" + tag
+	}
+	return name
+}
+
+// splitImage splits a container image reference into its name, tag and
+// digest.
+func splitImage(image string) (name, tag, digest string) {
+	name = image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
+
+// decodeSetting decodes a setting holding JSON, as Drone passes maps and
+// lists of maps.
+func decodeSetting(name, value string, out interface{}) error {
+	if value == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), out); err != nil {
+		log.Println("Error when decoding " + name)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// mergeKeys lists the keys identifying items of the lists a strategic merge
+// patch merges instead of replacing, by field name. Ports use containerPort
+// in pod specs and port in services.
+var mergeKeys = map[string][]string{
+	"containers":          {"name"},
+	"initContainers":      {"name"},
+	"ephemeralContainers": {"name"},
+	"volumes":             {"name"},
+	"env":                 {"name"},
+	"imagePullSecrets":    {"name"},
+	"volumeMounts":        {"mountPath"},
+	"volumeDevices":       {"devicePath"},
+	"ports":               {"containerPort", "port"},
+	"hostAliases":         {"ip"},
+	"conditions":          {"type"},
+}
+
+// strategicMerge applies a strategic merge patch to dst. Maps are merged
+// recursively, a null value removes a key, known lists of objects (see
+// mergeKeys) are merged item by item and any other value is replaced. The
+// "$patch: replace" and "$patch: delete" directives are supported on maps
+// and list items.
+func strategicMerge(dst, patch map[string]interface{}) map[string]interface{} {
+	switch patch["$patch"] {
+	case "replace":
+		out := map[string]interface{}{}
+		for k, v := range patch {
+			if k != "$patch" {
+				out[k] = v
+			}
+		}
+		return out
+	case "delete":
+		return nil
+	}
+
+	for key, val := range patch {
+		if val == nil {
+			delete(dst, key)
+			continue
+		}
+
+		switch p := val.(type) {
+		case map[string]interface{}:
+			d, ok := dst[key].(map[string]interface{})
+			if !ok {
+				d = map[string]interface{}{}
+			}
+			if merged := strategicMerge(d, p); merged != nil {
+				dst[key] = merged
+			} else {
+				delete(dst, key)
+			}
+		case []interface{}:
+			d, ok := dst[key].([]interface{})
+			if !ok {
+				dst[key] = p
+				continue
+			}
+			dst[key] = mergeList(key, d, p)
+		default:
+			dst[key] = val
+		}
+	}
+
+	return dst
+}
+
+// mergeList merges the items of patch into dst by their merge key, when the
+// list has one, and replaces the list otherwise.
+func mergeList(field string, dst, patch []interface{}) []interface{} {
+	key := listMergeKey(field, append(append([]interface{}{}, dst...), patch...))
+	if key == "" {
+		return patch
+	}
+
+	out := append([]interface{}{}, dst...)
+	for _, item := range patch {
+		p := item.(map[string]interface{})
+		found := -1
+		for i, existing := range out {
+			if reflect.DeepEqual(existing.(map[string]interface{})[key], p[key]) {
+				found = i
+				break
+			}
+		}
+
+		switch {
+		case p["$patch"] == "delete":
+			if found >= 0 {
+				out = append(out[:found], out[found+1:]...)
+			}
+		case found >= 0:
+			merged := strategicMerge(out[found].(map[string]interface{}), p)
+			if merged == nil {
+				out = append(out[:found], out[found+1:]...)
+			} else {
+				out[found] = merged
+			}
+		default:
+			out = append(out, strategicMerge(map[string]interface{}{}, p))
+		}
+	}
+
+	return out
+}
+
+// listMergeKey returns the merge key shared by every item of a list, or an
+// empty string if the list cannot be merged.
+func listMergeKey(field string, items []interface{}) string {
+	for _, key := range mergeKeys[field] {
+		ok := len(items) > 0
+		for _, item := range items {
+			m, isMap := item.(map[string]interface{})
+			if !isMap || m[key] == nil {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// jsonPatchOperation is a single RFC 6902 operation.
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// applyJSONPatch applies RFC 6902 operations to doc, in order.
+func applyJSONPatch(doc interface{}, operations []jsonPatchOperation) (interface{}, error) {
+	var err error
+	for _, op := range operations {
+		switch op.Op {
+		case "add":
+			doc, err = jsonPointerSet(doc, op.Path, deepCopyJSON(op.Value), true)
+		case "remove":
+			doc, _, err = jsonPointerRemove(doc, op.Path)
+		case "replace":
+			if _, err = jsonPointerGet(doc, op.Path); err == nil {
+				doc, err = jsonPointerSet(doc, op.Path, deepCopyJSON(op.Value), false)
+			}
+		case "move":
+			var val interface{}
+			if doc, val, err = jsonPointerRemove(doc, op.From); err == nil {
+				doc, err = jsonPointerSet(doc, op.Path, val, true)
+			}
+		case "copy":
+			var val interface{}
+			if val, err = jsonPointerGet(doc, op.From); err == nil {
+				doc, err = jsonPointerSet(doc, op.Path, deepCopyJSON(val), true)
+			}
+		case "test":
+			var val interface{}
+			// round trip both values so that numbers compare whatever their Go type
+			if val, err = jsonPointerGet(doc, op.Path); err == nil && !reflect.DeepEqual(deepCopyJSON(val), deepCopyJSON(op.Value)) {
+				err = fmt.Errorf("test failed at %s", op.Path)
+			}
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("json patch %s %s: %v", op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	parts := strings.Split(pointer[1:], "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+	}
+	return parts, nil
+}
+
+func jsonPointerGet(doc interface{}, pointer string) (interface{}, error) {
+	parts, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			val, ok := d[part]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", pointer)
+			}
+			doc = val
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, fmt.Errorf("%s not found", pointer)
+			}
+			doc = d[i]
+		default:
+			return nil, fmt.Errorf("%s not found", pointer)
+		}
+	}
+	return doc, nil
+}
+
+// jsonPointerSet sets the value at pointer. When insert is true, values are
+// inserted in arrays ("-" appends), otherwise they replace the existing item.
+func jsonPointerSet(doc interface{}, pointer string, val interface{}, insert bool) (interface{}, error) {
+	parts, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return val, nil
+	}
+
+	parentPointer := joinPointer(parts[:len(parts)-1])
+	parent, err := jsonPointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+
+	last := parts[len(parts)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = val
+	case []interface{}:
+		i := len(p)
+		if last != "-" {
+			if i, err = strconv.Atoi(last); err != nil || i < 0 || i > len(p) || (!insert && i == len(p)) {
+				return nil, fmt.Errorf("invalid array index %s", last)
+			}
+		}
+		if insert {
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+		}
+		p[i] = val
+		return jsonPointerSet(doc, parentPointer, p, false)
+	default:
+		return nil, fmt.Errorf("cannot set %s", pointer)
+	}
+	return doc, nil
+}
+
+func jsonPointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	val, err := jsonPointerGet(doc, pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	parts, _ := splitPointer(pointer)
+	if len(parts) == 0 {
+		return nil, val, nil
+	}
+
+	parentPointer := joinPointer(parts[:len(parts)-1])
+	parent, _ := jsonPointerGet(doc, parentPointer)
+
+	last := parts[len(parts)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		delete(p, last)
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		p = append(p[:i:i], p[i+1:]...)
+		doc, err = jsonPointerSet(doc, parentPointer, p, false)
+		return doc, val, err
+	}
+	return doc, val, nil
+}
+
+func joinPointer(parts []string) string {
+	var pointer string
+	for _, part := range parts {
+		pointer += "/" + strings.Replace(strings.Replace(part, "~", "~0", -1), "/", "~1", -1)
+	}
+	return pointer
+}
+
+// deepCopyJSON copies a JSON value so that patches never share state with
+// the objects they are applied to.
+func deepCopyJSON(val interface{}) interface{} {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return val
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return val
+	}
+	return out
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var val interface{}
+	if err := json.Unmarshal([]byte(s), &val); err != nil {
+		t.Fatalf("invalid JSON %s: %v", s, err)
+	}
+	return val
+}
+
+func TestStrategicMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		dst   string
+		patch string
+		want  string
+	}{
+		{
+			name:  "maps merge recursively",
+			dst:   `{"metadata":{"name":"web","labels":{"app":"web"}}}`,
+			patch: `{"metadata":{"labels":{"tier":"front"}}}`,
+			want:  `{"metadata":{"name":"web","labels":{"app":"web","tier":"front"}}}`,
+		},
+		{
+			name:  "null removes a key",
+			dst:   `{"metadata":{"labels":{"app":"web","tier":"front"}}}`,
+			patch: `{"metadata":{"labels":{"tier":null}}}`,
+			want:  `{"metadata":{"labels":{"app":"web"}}}`,
+		},
+		{
+			name:  "scalars and lists without merge key are replaced",
+			dst:   `{"replicas":2,"args":["a","b"]}`,
+			patch: `{"replicas":3,"args":["c"]}`,
+			want:  `{"replicas":3,"args":["c"]}`,
+		},
+		{
+			name:  "list items merge by key",
+			dst:   `{"containers":[{"name":"web","image":"nginx:1"},{"name":"side","image":"busybox"}]}`,
+			patch: `{"containers":[{"name":"web","image":"nginx:2"}]}`,
+			want:  `{"containers":[{"name":"web","image":"nginx:2"},{"name":"side","image":"busybox"}]}`,
+		},
+		{
+			name:  "unknown list items are appended",
+			dst:   `{"env":[{"name":"A","value":"1"}]}`,
+			patch: `{"env":[{"name":"B","value":"2"}]}`,
+			want:  `{"env":[{"name":"A","value":"1"},{"name":"B","value":"2"}]}`,
+		},
+		{
+			name:  "ports merge by containerPort",
+			dst:   `{"ports":[{"containerPort":80,"name":"http"}]}`,
+			patch: `{"ports":[{"containerPort":80,"protocol":"TCP"}]}`,
+			want:  `{"ports":[{"containerPort":80,"name":"http","protocol":"TCP"}]}`,
+		},
+		{
+			name:  "ports merge by port in services",
+			dst:   `{"ports":[{"port":80,"targetPort":8080}]}`,
+			patch: `{"ports":[{"port":80,"targetPort":9090}]}`,
+			want:  `{"ports":[{"port":80,"targetPort":9090}]}`,
+		},
+		{
+			name:  "$patch delete removes a list item",
+			dst:   `{"containers":[{"name":"web"},{"name":"side"}]}`,
+			patch: `{"containers":[{"name":"side","$patch":"delete"}]}`,
+			want:  `{"containers":[{"name":"web"}]}`,
+		},
+		{
+			name:  "$patch delete of a missing item is a no-op",
+			dst:   `{"containers":[{"name":"web"}]}`,
+			patch: `{"containers":[{"name":"other","$patch":"delete"}]}`,
+			want:  `{"containers":[{"name":"web"}]}`,
+		},
+		{
+			name:  "$patch replace replaces a map",
+			dst:   `{"metadata":{"labels":{"app":"web","tier":"front"}}}`,
+			patch: `{"metadata":{"labels":{"$patch":"replace","app":"api"}}}`,
+			want:  `{"metadata":{"labels":{"app":"api"}}}`,
+		},
+		{
+			name:  "$patch delete removes a map",
+			dst:   `{"metadata":{"name":"web","annotations":{"a":"b"}}}`,
+			patch: `{"metadata":{"annotations":{"$patch":"delete"}}}`,
+			want:  `{"metadata":{"name":"web"}}`,
+		},
+		{
+			name:  "$patch replace on a list item",
+			dst:   `{"containers":[{"name":"web","image":"nginx","args":["a"]}]}`,
+			patch: `{"containers":[{"name":"web","image":"httpd","$patch":"replace"}]}`,
+			want:  `{"containers":[{"name":"web","image":"httpd"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dst := decodeJSON(t, test.dst).(map[string]interface{})
+			patch := decodeJSON(t, test.patch).(map[string]interface{})
+			got := strategicMerge(dst, patch)
+			if want := decodeJSON(t, test.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		doc        string
+		operations string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "add to a map",
+			doc:        `{"a":1}`,
+			operations: `[{"op":"add","path":"/b","value":2}]`,
+			want:       `{"a":1,"b":2}`,
+		},
+		{
+			name:       "add inserts in an array",
+			doc:        `{"list":["a","c"]}`,
+			operations: `[{"op":"add","path":"/list/1","value":"b"}]`,
+			want:       `{"list":["a","b","c"]}`,
+		},
+		{
+			name:       "add with - appends",
+			doc:        `{"list":["a"]}`,
+			operations: `[{"op":"add","path":"/list/-","value":"b"}]`,
+			want:       `{"list":["a","b"]}`,
+		},
+		{
+			name:       "add at the array length appends",
+			doc:        `{"list":["a"]}`,
+			operations: `[{"op":"add","path":"/list/1","value":"b"}]`,
+			want:       `{"list":["a","b"]}`,
+		},
+		{
+			name:       "add out of bounds",
+			doc:        `{"list":["a"]}`,
+			operations: `[{"op":"add","path":"/list/3","value":"b"}]`,
+			wantErr:    true,
+		},
+		{
+			name:       "remove from an array",
+			doc:        `{"list":["a","b","c"]}`,
+			operations: `[{"op":"remove","path":"/list/1"}]`,
+			want:       `{"list":["a","c"]}`,
+		},
+		{
+			name:       "remove a missing key",
+			doc:        `{"a":1}`,
+			operations: `[{"op":"remove","path":"/b"}]`,
+			wantErr:    true,
+		},
+		{
+			name:       "replace an array item",
+			doc:        `{"list":["a","b"]}`,
+			operations: `[{"op":"replace","path":"/list/0","value":"z"}]`,
+			want:       `{"list":["z","b"]}`,
+		},
+		{
+			name:       "replace a missing key",
+			doc:        `{"a":1}`,
+			operations: `[{"op":"replace","path":"/b","value":2}]`,
+			wantErr:    true,
+		},
+		{
+			name:       "move between arrays",
+			doc:        `{"a":["x","y"],"b":[]}`,
+			operations: `[{"op":"move","from":"/a/0","path":"/b/-"}]`,
+			want:       `{"a":["y"],"b":["x"]}`,
+		},
+		{
+			name:       "copy a map",
+			doc:        `{"a":{"k":"v"}}`,
+			operations: `[{"op":"copy","from":"/a","path":"/b"}]`,
+			want:       `{"a":{"k":"v"},"b":{"k":"v"}}`,
+		},
+		{
+			name:       "test passes",
+			doc:        `{"replicas":2}`,
+			operations: `[{"op":"test","path":"/replicas","value":2},{"op":"replace","path":"/replicas","value":3}]`,
+			want:       `{"replicas":3}`,
+		},
+		{
+			name:       "test fails",
+			doc:        `{"replicas":2}`,
+			operations: `[{"op":"test","path":"/replicas","value":3}]`,
+			wantErr:    true,
+		},
+		{
+			name:       "escaped pointer",
+			doc:        `{"metadata":{"annotations":{"a/b":"1","c~d":"2"}}}`,
+			operations: `[{"op":"replace","path":"/metadata/annotations/a~1b","value":"x"},{"op":"remove","path":"/metadata/annotations/c~0d"}]`,
+			want:       `{"metadata":{"annotations":{"a/b":"x"}}}`,
+		},
+		{
+			name:       "unknown operation",
+			doc:        `{}`,
+			operations: `[{"op":"merge","path":"/a"}]`,
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var operations []jsonPatchOperation
+			if err := json.Unmarshal([]byte(test.operations), &operations); err != nil {
+				t.Fatal(err)
+			}
+			got, err := applyJSONPatch(decodeJSON(t, test.doc), operations)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decodeJSON(t, test.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
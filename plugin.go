@@ -1,32 +1,41 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	texttemplate "text/template"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
 	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type (
+	// Repo, Build and Job are the Drone metadata exposed to templates. Fields
+	// are only ever added to them, so that templates keep rendering across
+	// releases.
 	Repo struct {
 		Owner string
 		Name  string
+		Link  string
 	}
 
 	Build struct {
@@ -41,10 +50,23 @@ type (
 		Link    string
 		Started int64
 		Created int64
+
+		Message      string
+		PullRequest  int
+		SourceBranch string
+		TargetBranch string
+		DeployTo     string
+		Parent       int
+
+		// Semver is the parsed tag, nil unless the tag is a semantic version
+		Semver *semVersion
 	}
 
 	Job struct {
 		Started int64
+		Stage   string
+		Step    string
+		Runner  string
 	}
 
 	Config struct {
@@ -52,7 +74,72 @@ type (
 		Server    string
 		Token     string
 		Namespace string
-		Template  string
+		Templates []string
+		Engine    string
+		Strict    bool
+
+		Vars        string
+		ValuesFiles []string
+
+		Patches           []string
+		JSONPatches       string
+		Images            string
+		CommonLabels      string
+		CommonAnnotations string
+		NamePrefix        string
+		NameSuffix        string
+		NamespaceOverride string
+
+		Generators      string
+		PruneGenerated  bool
+		ConfigChecksums bool
+		Wait            bool
+
+		Timeout     time.Duration
+		Retries     int
+		RetryBudget time.Duration
+
+		Action     string
+		OutputFile string
+
+		Targets         string
+		ContainerImages string
+		Replicas        int
+		Revision        int
+		RollbackBuild   int
+
+		TemplateCA         string
+		TemplateSkipVerify bool
+		TemplateToken      string
+		TemplateUsername   string
+		TemplatePassword   string
+		TemplateHeaders    string
+		TemplateTimeout    time.Duration
+		TemplateSHA256     string
+
+		TemplatePublicKeys []string
+		TemplateSignature  string
+		RequireSignature   bool
+
+		LookupOffline bool
+
+		Provenance            bool
+		ProvenancePrefix      string
+		ProvenanceLabels      []string
+		ProvenanceAnnotations []string
+
+		ForceRollout bool
+
+		ReleaseLedger     bool
+		ReleaseLedgerKind string
+		ReleaseName       string
+		HistoryLimit      int
+
+		SnapshotFile      string
+		SnapshotConfigMap string
+
+		Atomic          bool
+		ContinueOnError bool
 	}
 
 	Plugin struct {
@@ -60,11 +147,48 @@ type (
 		Build  Build
 		Config Config
 		Job    Job
+		Values map[string]interface{}
+
+		// clientset serves template lookups, nil when rendering offline
+		clientset *kubernetes.Clientset
 	}
 )
 
-func (p Plugin) Exec() error {
+func (p Plugin) Exec(ctx context.Context) error {
+
+	if p.Config.Namespace == "" {
+		p.Config.Namespace = "default"
+	}
+
+	switch p.Config.Action {
+	case "", "apply":
+		return p.deploy(ctx)
+	case "render_only":
+		return p.renderOnly(ctx)
+	case "set-image":
+		return p.setImage(ctx)
+	case "scale":
+		return p.scale(ctx)
+	case "pause":
+		return p.pause(ctx, true)
+	case "resume":
+		return p.pause(ctx, false)
+	case "restart":
+		return p.restart(ctx)
+	case "rollback":
+		return p.rollback(ctx)
+	case "history":
+		return p.history(ctx)
+	case "restore":
+		return p.restore(ctx)
+	default:
+		return fmt.Errorf("unknown action %s", p.Config.Action)
+	}
+}
 
+// checkCluster stops the plugin when the cluster connection settings are
+// missing.
+func (p Plugin) checkCluster() {
 	if p.Config.Server == "" {
 		log.Fatal("KUBERNETES_SERVER is not defined")
 	}
@@ -74,199 +198,407 @@ func (p Plugin) Exec() error {
 	if p.Config.Cert == "" {
 		log.Fatal("KUBERNETES_CERT is not defined")
 	}
-	if p.Config.Namespace == "" {
-		p.Config.Namespace = "default"
-	}
-	if p.Config.Template == "" {
+}
+
+// buildManifests renders the templates and returns the final list of
+// objects, with every transformation applied.
+func (p Plugin) buildManifests(ctx context.Context, clientset *kubernetes.Clientset) ([]manifest, error) {
+	if len(p.Config.Templates) == 0 {
 		log.Fatal("KUBERNETES_TEMPLATE is not defined")
 	}
 
-	clientset, err := p.getClient()
+	p.clientset = clientset
+	var err error
+	p.Values, err = p.loadValues()
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := p.getTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// decode every document before touching the cluster, so that a broken
+	// template does not leave a half applied release behind
+	var manifests []manifest
+	for _, template := range templates {
+		docs, err := decodeManifests(template)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, docs...)
+	}
+
+	manifests, err = p.applyGenerators(manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err = p.applyOverlays(manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Config.ConfigChecksums {
+		if err := p.applyChecksums(manifests); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Config.Provenance {
+		if err := p.applyProvenance(manifests); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Config.ForceRollout {
+		if err := p.applyForceRollout(manifests, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifests, nil
+}
+
+// deploy applies the rendered templates to the cluster.
+func (p Plugin) deploy(ctx context.Context) error {
+	p.checkCluster()
+
+	// a wrong ledger kind would only fail after the release is applied
+	if p.Config.ReleaseLedger {
+		if err := p.checkReleaseLedgerKind(); err != nil {
+			return err
+		}
+	}
+
+	clientset, err := p.getClient(ctx)
 	if err != nil {
 		return err
 	}
 
-	template, err := p.getTemplate()
+	manifests, err := p.buildManifests(ctx, clientset)
 	if err != nil {
 		return err
 	}
-	decode := scheme.Codecs.UniversalDeserializer().Decode
 
-	// iterate if several yalm files separated by ---
-	for _, s := range strings.Split(template, "---") {
-		obj, _, err := decode([]byte(s), nil, nil)
-		if err != nil {
-			log.Println("Error when decoding template YAML")
+	if p.Config.OutputFile != "" {
+		if err := writeManifests(p.Config.OutputFile, manifests); err != nil {
 			return err
 		}
+	}
 
-		switch o := obj.(type) {
-		// appsv1
-		case *appsv1.DaemonSet:
-			daemonSetSet := clientset.AppsV1().DaemonSets(p.Config.Namespace)
-			err := applyDaemonSetAppsV1(o, daemonSetSet)
-			if err != nil {
-				return err
-			}
+	if p.snapshotEnabled() {
+		if err := p.snapshot(ctx, clientset, manifests); err != nil {
+			return err
+		}
+	}
 
-		case *appsv1.Deployment:
-			deploymentSet := clientset.AppsV1().Deployments(p.Config.Namespace)
-			err := applyDeploymentAppsV1(o, deploymentSet)
-			if err != nil {
-				return err
-			}
+	var prior []priorState
+	if p.Config.Atomic {
+		if prior, err = p.recordPriorStates(ctx, clientset, manifests); err != nil {
+			return err
+		}
+	}
 
-		case *appsv1.ReplicaSet:
-			replicatSetSet := clientset.AppsV1().ReplicaSets(p.Config.Namespace)
-			err := applyReplicaSetAppsV1(o, replicatSetSet)
-			if err != nil {
-				return err
+	started := time.Now()
+	applied, err := p.applyManifests(ctx, clientset, manifests)
+	if err != nil && p.Config.Atomic {
+		log.Println("Deploy failed: " + err.Error())
+		if revertErr := p.revert(ctx, prior, applied); revertErr != nil {
+			err = fmt.Errorf("%v, and the revert failed: %v", err, revertErr)
+		} else {
+			err = fmt.Errorf("%v, changes reverted", err)
+		}
+	}
+	if p.Config.ReleaseLedger {
+		if recordErr := p.recordRelease(ctx, manifests, applied, started, err); recordErr != nil {
+			log.Println("Error when recording the release: " + recordErr.Error())
+			if err == nil {
+				err = recordErr
 			}
+		}
+	}
+	return err
+}
 
-		case *appsv1.StatefulSet:
-			statefulSetSet := clientset.AppsV1().StatefulSets(p.Config.Namespace)
-			err := applyStatefulSetAppsV1(o, statefulSetSet)
-			if err != nil {
-				return err
-			}
+// applyManifests applies manifests in order, then waits for rollouts and
+// prunes generated objects when configured. It returns the number of
+// manifests applied. With continue_on_error, failing manifests do not stop
+// the run; their errors are returned together as an *objectErrors.
+func (p Plugin) applyManifests(ctx context.Context, clientset *kubernetes.Clientset, manifests []manifest) (int, error) {
+	failures := &objectErrors{Total: len(manifests)}
+	var succeeded []manifest
+	for i, m := range manifests {
+		if !isSupported(m.Object) && ctx.Err() == nil {
+			log.Println("Skipping " + m.String() + ": " + errUnsupportedKind.Error())
+			failures.Skipped = append(failures.Skipped, p.newObjectError(i, m, errUnsupportedKind))
+			continue
+		}
 
-		// appsv1beta1
-		case *appsv1beta1.Deployment:
-			deploymentSet := clientset.AppsV1beta1().Deployments(p.Config.Namespace)
-			err := applyDeploymentAppsV1beta1(o, deploymentSet)
-			if err != nil {
-				return err
+		var err error
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else {
+			log.Println("Applying " + m.String())
+			err = p.retry(ctx, m.String(), func() error {
+				return p.apply(clientset, m.Object)
+			})
+		}
+		if err != nil && p.Config.ContinueOnError && ctx.Err() == nil {
+			log.Println("Error when applying " + m.String() + ": " + err.Error())
+			failures.Errors = append(failures.Errors, p.newObjectError(i, m, err))
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Println("Run interrupted: " + ctx.Err().Error())
 			}
-
-		case *appsv1beta1.StatefulSet:
-			statefulSetSet := clientset.AppsV1beta1().StatefulSets(p.Config.Namespace)
-			err := applyStatefulSetAppsV1beta1(o, statefulSetSet)
-			if err != nil {
-				return err
+			reportProgress(manifests, i, failures)
+			if len(failures.Errors) > 0 || len(failures.Skipped) > 0 {
+				failures.printSummary()
 			}
+			return i, err
+		}
+		succeeded = append(succeeded, m)
+		failures.Applied++
+	}
 
-		// appsv1beta2
-		case *appsv1beta2.DaemonSet:
-			daemonSetSet := clientset.AppsV1beta2().DaemonSets(p.Config.Namespace)
-			err := applyDaemonSetAppsV1beta2(o, daemonSetSet)
-			if err != nil {
-				return err
+	// older generated objects may only go once nothing uses them anymore
+	if p.Config.Wait || p.Config.PruneGenerated || p.Config.Atomic {
+		if err := p.waitForRollouts(ctx, clientset, succeeded); err != nil {
+			if len(failures.Errors) > 0 || len(failures.Skipped) > 0 {
+				failures.printSummary()
 			}
+			return len(manifests), err
+		}
+	}
 
-		case *appsv1beta2.Deployment:
-			deploymentSet := clientset.AppsV1beta2().Deployments(p.Config.Namespace)
-			err := applyDeploymentAppsV1beta2(o, deploymentSet)
-			if err != nil {
-				return err
-			}
+	if len(failures.Errors) > 0 {
+		if p.Config.PruneGenerated {
+			log.Println("Not pruning generated objects, some objects failed to apply")
+		}
+		failures.printSummary()
+		return len(manifests), failures
+	}
 
-		case *appsv1beta2.ReplicaSet:
-			replicatSetSet := clientset.AppsV1beta2().ReplicaSets(p.Config.Namespace)
-			err := applyReplicaSetAppsV1beta2(o, replicatSetSet)
-			if err != nil {
-				return err
-			}
+	var err error
+	if p.Config.PruneGenerated {
+		err = p.pruneGenerated(ctx, clientset, manifests)
+	}
+	if len(failures.Skipped) > 0 {
+		failures.printSummary()
+	}
+	return len(manifests), err
+}
 
-		case *appsv1beta2.StatefulSet:
-			statefulSetSet := clientset.AppsV1beta2().StatefulSets(p.Config.Namespace)
-			err := applyStatefulSetAppsV1beta2(o, statefulSetSet)
-			if err != nil {
-				return err
-			}
+// reportProgress prints which objects were applied before the run stopped
+// and which ones were not, failed and skipped ones being listed by the
+// summary.
+func reportProgress(manifests []manifest, applied int, failures *objectErrors) {
+	for i, m := range manifests[:applied] {
+		if !failures.failed(i) {
+			log.Println("Applied: " + m.String())
+		}
+	}
+	for _, m := range manifests[applied:] {
+		log.Println("Not applied: " + m.String())
+	}
+}
 
-		// corev1
-		case *corev1.ConfigMap:
-			configMapSet := clientset.CoreV1().ConfigMaps(p.Config.Namespace)
-			err := applyConfigMap(o, configMapSet)
+// apply creates or updates obj with the client matching its API group and
+// version.
+func (p Plugin) apply(clientset *kubernetes.Clientset, obj runtime.Object) error {
+	namespace := p.namespaceOf(obj)
+	switch o := obj.(type) {
+	// appsv1
+	case *appsv1.DaemonSet:
+		daemonSetSet := clientset.AppsV1().DaemonSets(namespace)
+		err := applyDaemonSetAppsV1(o, daemonSetSet)
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+	case *appsv1.Deployment:
+		deploymentSet := clientset.AppsV1().Deployments(namespace)
+		err := applyDeploymentAppsV1(o, deploymentSet)
+		if err != nil {
+			return err
+		}
 
-		case *corev1.PersistentVolume:
-			persistentVolumeSet := clientset.CoreV1().PersistentVolumes()
-			err := applyPersistentVolume(o, persistentVolumeSet)
+	case *appsv1.ReplicaSet:
+		replicatSetSet := clientset.AppsV1().ReplicaSets(namespace)
+		err := applyReplicaSetAppsV1(o, replicatSetSet)
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+	case *appsv1.StatefulSet:
+		statefulSetSet := clientset.AppsV1().StatefulSets(namespace)
+		err := applyStatefulSetAppsV1(o, statefulSetSet)
+		if err != nil {
+			return err
+		}
 
-		case *corev1.PersistentVolumeClaim:
-			persistentVolumeClaimSet := clientset.CoreV1().PersistentVolumeClaims(p.Config.Namespace)
-			err := applyPersistentVolumeClaim(o, persistentVolumeClaimSet)
+	// appsv1beta1
+	case *appsv1beta1.Deployment:
+		deploymentSet := clientset.AppsV1beta1().Deployments(namespace)
+		err := applyDeploymentAppsV1beta1(o, deploymentSet)
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+	case *appsv1beta1.StatefulSet:
+		statefulSetSet := clientset.AppsV1beta1().StatefulSets(namespace)
+		err := applyStatefulSetAppsV1beta1(o, statefulSetSet)
+		if err != nil {
+			return err
+		}
 
-		case *corev1.Pod:
-			podSet := clientset.CoreV1().Pods(p.Config.Namespace)
-			err := applyPod(o, podSet)
+	// appsv1beta2
+	case *appsv1beta2.DaemonSet:
+		daemonSetSet := clientset.AppsV1beta2().DaemonSets(namespace)
+		err := applyDaemonSetAppsV1beta2(o, daemonSetSet)
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+	case *appsv1beta2.Deployment:
+		deploymentSet := clientset.AppsV1beta2().Deployments(namespace)
+		err := applyDeploymentAppsV1beta2(o, deploymentSet)
+		if err != nil {
+			return err
+		}
 
-		case *corev1.ReplicationController:
-			replicationControllerSet := clientset.CoreV1().ReplicationControllers(p.Config.Namespace)
-			err := applyReplicationController(o, replicationControllerSet)
+	case *appsv1beta2.ReplicaSet:
+		replicatSetSet := clientset.AppsV1beta2().ReplicaSets(namespace)
+		err := applyReplicaSetAppsV1beta2(o, replicatSetSet)
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+	case *appsv1beta2.StatefulSet:
+		statefulSetSet := clientset.AppsV1beta2().StatefulSets(namespace)
+		err := applyStatefulSetAppsV1beta2(o, statefulSetSet)
+		if err != nil {
+			return err
+		}
 
-		case *corev1.Service:
-			serviceSet := clientset.CoreV1().Services(p.Config.Namespace)
-			err := applyService(o, serviceSet)
+	// corev1
+	case *corev1.ConfigMap:
+		configMapSet := clientset.CoreV1().ConfigMaps(namespace)
+		err := applyConfigMap(o, configMapSet)
 
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
+		}
 
-		// extensionsv1beta1
-		case *extensionsv1beta1.DaemonSet:
-			daemonSetSet := clientset.ExtensionsV1beta1().DaemonSets(p.Config.Namespace)
-			err := applyDaemonSetExtensionsV1beta1(o, daemonSetSet)
-			if err != nil {
-				return err
-			}
+	case *corev1.PersistentVolume:
+		persistentVolumeSet := clientset.CoreV1().PersistentVolumes()
+		err := applyPersistentVolume(o, persistentVolumeSet)
 
-		case *extensionsv1beta1.Deployment:
-			deploymentSet := clientset.ExtensionsV1beta1().Deployments(p.Config.Namespace)
-			err := applyDeploymentExtensionsV1beta1(o, deploymentSet)
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
+		}
 
-		case *extensionsv1beta1.Ingress:
-			ingressSet := clientset.ExtensionsV1beta1().Ingresses(p.Config.Namespace)
-			err := applyIngressExtensionsV1beta1(o, ingressSet)
+	case *corev1.PersistentVolumeClaim:
+		persistentVolumeClaimSet := clientset.CoreV1().PersistentVolumeClaims(namespace)
+		err := applyPersistentVolumeClaim(o, persistentVolumeClaimSet)
 
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
+		}
 
-		case *extensionsv1beta1.ReplicaSet:
-			replicatSetSet := clientset.ExtensionsV1beta1().ReplicaSets(p.Config.Namespace)
-			err := applyReplicaSetExtensionsV1beta1(o, replicatSetSet)
-			if err != nil {
-				return err
-			}
+	case *corev1.Pod:
+		podSet := clientset.CoreV1().Pods(namespace)
+		err := applyPod(o, podSet)
+
+		if err != nil {
+			return err
+		}
+
+	case *corev1.ReplicationController:
+		replicationControllerSet := clientset.CoreV1().ReplicationControllers(namespace)
+		err := applyReplicationController(o, replicationControllerSet)
+
+		if err != nil {
+			return err
+		}
+
+	case *corev1.Secret:
+		secretSet := clientset.CoreV1().Secrets(namespace)
+		err := applySecret(o, secretSet)
+
+		if err != nil {
+			return err
+		}
+
+	case *corev1.Service:
+		serviceSet := clientset.CoreV1().Services(namespace)
+		err := applyService(o, serviceSet)
+
+		if err != nil {
+			return err
+		}
 
-		default:
-			fmt.Printf("other")
+	// extensionsv1beta1
+	case *extensionsv1beta1.DaemonSet:
+		daemonSetSet := clientset.ExtensionsV1beta1().DaemonSets(namespace)
+		err := applyDaemonSetExtensionsV1beta1(o, daemonSetSet)
+		if err != nil {
+			return err
+		}
+
+	case *extensionsv1beta1.Deployment:
+		deploymentSet := clientset.ExtensionsV1beta1().Deployments(namespace)
+		err := applyDeploymentExtensionsV1beta1(o, deploymentSet)
+		if err != nil {
+			return err
+		}
+
+	case *extensionsv1beta1.Ingress:
+		ingressSet := clientset.ExtensionsV1beta1().Ingresses(namespace)
+		err := applyIngressExtensionsV1beta1(o, ingressSet)
+
+		if err != nil {
+			return err
+		}
+
+	case *extensionsv1beta1.ReplicaSet:
+		replicatSetSet := clientset.ExtensionsV1beta1().ReplicaSets(namespace)
+		err := applyReplicaSetExtensionsV1beta1(o, replicatSetSet)
+		if err != nil {
+			return err
 		}
+
+	default:
+		return fmt.Errorf("unsupported object %T", obj)
 	}
 
 	return nil
 }
 
-func (p Plugin) getClient() (*kubernetes.Clientset, error) {
+// namespaceOf returns the namespace set in the object metadata, or the
+// configured namespace.
+func (p Plugin) namespaceOf(obj runtime.Object) string {
+	if accessor, err := meta.Accessor(obj); err == nil && accessor.GetNamespace() != "" {
+		return accessor.GetNamespace()
+	}
+	return p.Config.Namespace
+}
+
+// isNamespaced reports whether objects of a supported kind live in a
+// namespace.
+func isNamespaced(kind string) bool {
+	return kind != "PersistentVolume"
+}
+
+func (p Plugin) getClient(ctx context.Context) (*kubernetes.Clientset, error) {
 
 	cert, err := base64.StdEncoding.DecodeString(p.Config.Cert)
 	config := clientcmdapi.NewConfig()
 	config.Clusters["drone"] = &clientcmdapi.Cluster{
-		Server: p.Config.Server,
+		Server:                   p.Config.Server,
 		CertificateAuthorityData: cert,
 	}
 	config.AuthInfos["drone"] = &clientcmdapi.AuthInfo{
@@ -285,53 +617,170 @@ func (p Plugin) getClient() (*kubernetes.Clientset, error) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	actualCfg.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &contextTransport{ctx: ctx, next: rt}
+	}
 
 	return kubernetes.NewForConfig(actualCfg)
 }
 
-func (p Plugin) getTemplate() (string, error) {
+// contextTransport binds every API request to the plugin context, so that a
+// cancelled build or an expired deadline aborts in-flight requests instead of
+// waiting on a hung connection.
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(req.WithContext(t.ctx))
+}
 
-	var template string
-	u, err := url.ParseRequestURI(p.Config.Template)
-	if err == nil {
-		switch u.Scheme {
-		case "http", "https":
-			defaultTransport := http.DefaultTransport.(*http.Transport)
-			cli := &http.Transport{
-				Proxy:                 defaultTransport.Proxy,
-				DialContext:           defaultTransport.DialContext,
-				MaxIdleConns:          defaultTransport.MaxIdleConns,
-				IdleConnTimeout:       defaultTransport.IdleConnTimeout,
-				ExpectContinueTimeout: defaultTransport.ExpectContinueTimeout,
-				TLSHandshakeTimeout:   defaultTransport.TLSHandshakeTimeout,
-				TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-			}
+// getTemplates expands every configured template location (file, directory,
+// glob pattern or URL) and renders each file with the plugin context. The
+// result keeps the configured order, files matched by a directory or a glob
+// are sorted by name.
+func (p Plugin) getTemplates(ctx context.Context) ([]renderedTemplate, error) {
 
-			client := &http.Client{Transport: cli}
-			res, err := client.Get(p.Config.Template)
+	var templates []renderedTemplate
+	for _, location := range p.Config.Templates {
+		sources, err := expandTemplate(location)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, source := range sources {
+			raw, err := p.readTemplate(ctx, source)
 			if err != nil {
-				log.Println("Error when getting template URL")
-				return template, err
+				return nil, err
 			}
-			defer res.Body.Close()
-			out, err := ioutil.ReadAll(res.Body)
+
+			out, err := p.render(ctx, source, raw)
 			if err != nil {
-				log.Println("Error when reading template URL")
-				return template, err
+				log.Println("Error when rendering template " + source)
+				return nil, err
 			}
-			template = string(out)
+			templates = append(templates, renderedTemplate{Source: source, Content: out})
+		}
+	}
+
+	return templates, nil
+}
+
+// render executes a template with the configured engine. In strict mode,
+// references that resolve to nothing and leftover delimiters fail the
+// rendering.
+func (p Plugin) render(ctx context.Context, source, template string) (string, error) {
+	lookup := func(kind, namespace, name string) (map[string]interface{}, error) {
+		return p.lookup(ctx, kind, namespace, name)
+	}
+
+	var out string
+	var err error
+	switch p.Config.Engine {
+	case "", "handlebars":
+		if p.Config.Strict {
+			if err := checkHandlebars(source, template, p); err != nil {
+				return "", err
+			}
+		}
+		// raymond already has a lookup helper, for fields of the context
+		out, err = RenderTrimWithHelpers(template, p, map[string]interface{}{
+			"kubeLookup": func(kind, namespace, name string) interface{} {
+				obj, err := lookup(kind, namespace, name)
+				if err != nil {
+					panic(err)
+				}
+				return obj
+			},
+		})
+	case "gotemplate":
+		out, err = RenderGoTemplate(source, template, p, p.Config.Strict, texttemplate.FuncMap{
+			"lookup":     lookup,
+			"kubeLookup": lookup,
+		})
+	default:
+		return "", fmt.Errorf("unknown template engine %s", p.Config.Engine)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if p.Config.Strict {
+		if err := checkDelimiters(source, out); err != nil {
+			return "", err
+		}
+	}
+	return out, nil
+}
+
+// expandTemplate resolves a template location to the list of files it
+// designates. URLs are returned untouched.
+func expandTemplate(location string) ([]string, error) {
+	if u, err := url.ParseRequestURI(location); err == nil {
+		switch u.Scheme {
+		case "http", "https":
+			return []string{location}, nil
 		case "file":
-			fmt.Println(u.Path)
-			out, err := ioutil.ReadFile(u.Path)
-			if err != nil {
-				log.Println("Error when reading template file")
-				return template, err
+			location = u.Path
+		}
+	}
+
+	if strings.ContainsAny(location, "*?[") {
+		matches, err := filepath.Glob(location)
+		if err != nil {
+			log.Println("Error when expanding template pattern " + location)
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("template pattern %s does not match any file", location)
+		}
+		sort.Strings(matches)
+		return matches, nil
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		log.Println("Error when reading template file")
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{location}, nil
+	}
+
+	files, err := ioutil.ReadDir(location)
+	if err != nil {
+		log.Println("Error when reading template directory " + location)
+		return nil, err
+	}
+	var sources []string
+	for _, file := range files {
+		switch filepath.Ext(file.Name()) {
+		case ".yml", ".yaml", ".json":
+			if !file.IsDir() {
+				sources = append(sources, filepath.Join(location, file.Name()))
 			}
-			template = string(out)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("template directory %s does not contain any manifest", location)
+	}
+
+	return sources, nil
+}
+
+// readTemplate returns the raw content of a single template file or URL.
+func (p Plugin) readTemplate(ctx context.Context, source string) (string, error) {
+
+	var template string
+	u, err := url.ParseRequestURI(source)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		template, err = p.fetchTemplate(ctx, source)
+		if err != nil {
+			return template, err
 		}
 	} else {
-		fmt.Println("file")
-		file, err := filepath.Abs(p.Config.Template)
+		file, err := filepath.Abs(source)
 		if err != nil {
 			log.Println("Error when getting template path")
 			return template, err
@@ -344,5 +793,5 @@ func (p Plugin) getTemplate() (string, error) {
 		template = string(out)
 	}
 
-	return RenderTrim(template, p)
+	return template, nil
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// changeCauseAnnotation is shown by kubectl rollout history for each
+// revision of a workload.
+const changeCauseAnnotation = "kubernetes.io/change-cause"
+
+// provenanceFields lists the build metadata provenance labels and
+// annotations can carry.
+var provenanceFields = []string{"repo", "commit", "branch", "build", "link", "author"}
+
+// applyProvenance labels and annotates every object, and the pod template of
+// workloads, with the build that applied it, and sets the change cause of
+// Deployments, StatefulSets and DaemonSets.
+func (p Plugin) applyProvenance(manifests []manifest) error {
+	values := map[string]string{
+		"repo":   strings.Trim(p.Repo.Owner+"/"+p.Repo.Name, "/"),
+		"commit": p.Build.Commit,
+		"branch": p.Build.Branch,
+		"build":  strconv.Itoa(p.Build.Number),
+		"link":   p.Build.Link,
+		"author": p.Build.Author,
+	}
+
+	labels, err := p.provenanceEntries(p.Config.ProvenanceLabels, values, true)
+	if err != nil {
+		return err
+	}
+	annotations, err := p.provenanceEntries(p.Config.ProvenanceAnnotations, values, false)
+	if err != nil {
+		return err
+	}
+
+	changeCause := fmt.Sprintf("Drone build #%d of %s", p.Build.Number, values["repo"])
+	if p.Build.Commit != "" {
+		changeCause += " (" + truncate(p.Build.Commit, 8) + ")"
+	}
+	if p.Build.Link != "" {
+		changeCause += ": " + p.Build.Link
+	}
+
+	return transformManifests(manifests, func(m manifest, u map[string]interface{}) error {
+		setStringMapEntries(u, labels, "metadata", "labels")
+		setStringMapEntries(u, annotations, "metadata", "annotations")
+		if template := podTemplate(u); template != nil && m.Kind != "Pod" {
+			setStringMapEntries(template, labels, "metadata", "labels")
+			setStringMapEntries(template, annotations, "metadata", "annotations")
+		}
+		if isWorkload(m.Kind) {
+			setStringMapEntries(u, map[string]string{changeCauseAnnotation: changeCause}, "metadata", "annotations")
+		}
+		return nil
+	})
+}
+
+// provenanceEntries maps the selected fields to their prefixed keys. Label
+// values are sanitized to meet the label value syntax.
+func (p Plugin) provenanceEntries(fields []string, values map[string]string, label bool) (map[string]string, error) {
+	entries := map[string]string{}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		value, ok := values[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown provenance field %s, expected one of %s", field, strings.Join(provenanceFields, ", "))
+		}
+		if label {
+			value = labelValue(value)
+		}
+		if value != "" {
+			entries[p.Config.ProvenancePrefix+field] = value
+		}
+	}
+	return entries, nil
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// labelValue turns s into a valid label value: at most 63 characters among
+// alphanumerics, '-', '_' and '.', starting and ending with an alphanumeric.
+func labelValue(s string) string {
+	s = invalidLabelChars.ReplaceAllString(s, "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "-_.")
+}
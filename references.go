@@ -0,0 +1,90 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// visitReferences calls visit for every reference an object holds to another
+// object by name: ConfigMaps, Secrets and PersistentVolumeClaims used by pod
+// templates, Services and Secrets used by Ingresses and the governing Service
+// of StatefulSets. The reference is replaced by the name visit returns.
+func visitReferences(u map[string]interface{}, visit func(kind, name string) string) {
+	ref := func(kind string, m map[string]interface{}, fields ...string) {
+		name, ok, _ := unstructured.NestedString(m, fields...)
+		if ok && name != "" {
+			unstructured.SetNestedField(m, visit(kind, name), fields...)
+		}
+	}
+
+	kind, _, _ := unstructured.NestedString(u, "kind")
+	switch kind {
+	case "Ingress":
+		ref("Service", u, "spec", "backend", "serviceName")
+		for _, rule := range nestedMaps(u, "spec", "rules") {
+			for _, path := range nestedMaps(rule, "http", "paths") {
+				ref("Service", path, "backend", "serviceName")
+			}
+		}
+		for _, tls := range nestedMaps(u, "spec", "tls") {
+			ref("Secret", tls, "secretName")
+		}
+	case "StatefulSet":
+		ref("Service", u, "spec", "serviceName")
+	}
+
+	template := podTemplate(u)
+	if template == nil {
+		return
+	}
+
+	for _, volume := range nestedMaps(template, "spec", "volumes") {
+		ref("ConfigMap", volume, "configMap", "name")
+		ref("Secret", volume, "secret", "secretName")
+		ref("PersistentVolumeClaim", volume, "persistentVolumeClaim", "claimName")
+		for _, source := range nestedMaps(volume, "projected", "sources") {
+			ref("ConfigMap", source, "configMap", "name")
+			ref("Secret", source, "secret", "name")
+		}
+	}
+	for _, secret := range nestedMaps(template, "spec", "imagePullSecrets") {
+		ref("Secret", secret, "name")
+	}
+	for _, container := range containers(template) {
+		for _, envFrom := range nestedMaps(container, "envFrom") {
+			ref("ConfigMap", envFrom, "configMapRef", "name")
+			ref("Secret", envFrom, "secretRef", "name")
+		}
+		for _, env := range nestedMaps(container, "env") {
+			ref("ConfigMap", env, "valueFrom", "configMapKeyRef", "name")
+			ref("Secret", env, "valueFrom", "secretKeyRef", "name")
+		}
+	}
+}
+
+// renameReferences points references to renamed objects, indexed by
+// "Kind/old-name", to their new names.
+func renameReferences(u map[string]interface{}, renamed map[string]string) {
+	if len(renamed) == 0 {
+		return
+	}
+	visitReferences(u, func(kind, name string) string {
+		if newName, ok := renamed[kind+"/"+name]; ok {
+			return newName
+		}
+		return name
+	})
+}
+
+// nestedMaps returns the objects of the list at path.
+func nestedMaps(u map[string]interface{}, fields ...string) []map[string]interface{} {
+	val, _, _ := unstructured.NestedFieldNoCopy(u, fields...)
+	items, _ := val.([]interface{})
+
+	var out []map[string]interface{}
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
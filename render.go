@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// renderOnly writes the final manifests to the output file, or to the
+// standard output, without applying them.
+func (p Plugin) renderOnly(ctx context.Context) error {
+	// no cluster connection is made, template lookups return empty objects
+	manifests, err := p.buildManifests(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if p.Config.OutputFile == "" {
+		out, err := marshalManifests(manifests)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return writeManifests(p.Config.OutputFile, manifests)
+}
+
+// writeManifests writes manifests to file as a multi-document YAML stream.
+func writeManifests(file string, manifests []manifest) error {
+	out, err := marshalManifests(manifests)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, out, 0644); err != nil {
+		log.Println("Error when writing " + file)
+		return err
+	}
+	log.Printf("Wrote %d objects to %s", len(manifests), file)
+	return nil
+}
+
+// marshalManifests normalizes every manifest (sorted keys, no status nor
+// empty creation timestamp) and joins them in a YAML stream, each document
+// being preceded by a comment naming its source.
+func marshalManifests(manifests []manifest) ([]byte, error) {
+	var out bytes.Buffer
+	for _, m := range manifests {
+		u, err := toUnstructured(m.Object)
+		if err != nil {
+			return nil, err
+		}
+		delete(u, "status")
+		removeNullTimestamp(u)
+		if template := podTemplate(u); template != nil {
+			removeNullTimestamp(template)
+		}
+
+		doc, err := yaml.Marshal(u)
+		if err != nil {
+			log.Println("Error when encoding " + m.String())
+			return nil, err
+		}
+		fmt.Fprintf(&out, "---\n# %s\n", m.String())
+		out.Write(doc)
+	}
+	return out.Bytes(), nil
+}
+
+func removeNullTimestamp(u map[string]interface{}) {
+	if ts, ok, _ := unstructured.NestedFieldNoCopy(u, "metadata", "creationTimestamp"); ok && ts == nil {
+		unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+	}
+}
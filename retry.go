@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	retryInitialDelay = 500 * time.Millisecond
+	retryMaxDelay     = 30 * time.Second
+	retryJitter       = 0.5
+)
+
+// retry calls fn until it succeeds, returns an error that cannot succeed on
+// retry, or the retry budget (number of retries and total time) is spent.
+// The delay grows exponentially with jitter and honours any Retry-After
+// sent by the API server. Waiting stops as soon as ctx is done.
+func (p Plugin) retry(ctx context.Context, name string, fn func() error) error {
+	deadline := time.Now().Add(p.Config.RetryBudget)
+	delay := retryInitialDelay
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+		if attempt >= p.Config.Retries {
+			log.Printf("Giving up on %s after %d retries", name, attempt)
+			return err
+		}
+
+		sleep := wait.Jitter(delay, retryJitter)
+		if seconds, ok := errors.SuggestsClientDelay(err); ok {
+			if after := time.Duration(seconds) * time.Second; after > sleep {
+				sleep = after
+			}
+		}
+		if time.Now().Add(sleep).After(deadline) {
+			log.Println("Retry budget exhausted for " + name)
+			return err
+		}
+
+		log.Printf("Transient error on %s, retrying in %s: %v", name, sleep.Round(time.Millisecond), err)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// isRetryable reports whether err is a transient failure, such as throttling,
+// an unavailable API server or a lost connection. Errors that would fail the
+// same way again (validation, authorization, bad requests) are not retried.
+func isRetryable(err error) bool {
+	switch {
+	case errors.IsInvalid(err),
+		errors.IsForbidden(err),
+		errors.IsUnauthorized(err),
+		errors.IsBadRequest(err),
+		errors.IsNotFound(err),
+		errors.IsMethodNotSupported(err),
+		errors.IsNotAcceptable(err),
+		errors.IsUnsupportedMediaType(err),
+		errors.IsGone(err):
+		return false
+	case errors.IsTooManyRequests(err),
+		errors.IsServerTimeout(err),
+		errors.IsTimeout(err),
+		errors.IsServiceUnavailable(err),
+		errors.IsInternalError(err),
+		errors.IsConflict(err),
+		errors.IsAlreadyExists(err):
+		// Conflicts and AlreadyExists come from racing with another writer;
+		// retrying the whole apply re-reads the live object, and recording a
+		// release takes the next revision.
+		return true
+	}
+
+	if status, ok := err.(errors.APIStatus); ok {
+		code := int(status.Status().Code)
+		return code >= http.StatusInternalServerError && code != http.StatusNotImplemented
+	}
+
+	if statusErr, ok := err.(*httpStatusError); ok {
+		return statusErr.retryable()
+	}
+
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if _, ok := err.(*net.OpError); ok {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	return utilnet.IsProbableEOF(err) ||
+		utilnet.IsConnectionReset(err) ||
+		strings.Contains(err.Error(), "etcdserver: leader changed") ||
+		strings.Contains(err.Error(), "etcdserver: request timed out")
+}
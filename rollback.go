@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// deploymentRevisionAnnotation holds the revision of the ReplicaSets of
+	// a Deployment.
+	deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+	// podTemplateHashLabel is added by the Deployment controller to the
+	// template of each ReplicaSet.
+	podTemplateHashLabel = "pod-template-hash"
+)
+
+// revision is a past state of a workload: a ReplicaSet for Deployments, a
+// ControllerRevision for StatefulSets and DaemonSets.
+type revision struct {
+	Number      int64
+	Build       string
+	ChangeCause string
+
+	// Template is the pod template of a ReplicaSet.
+	Template map[string]interface{}
+
+	// Patch restores a ControllerRevision.
+	Patch []byte
+}
+
+// rollback restores live workloads to a previous revision: the one before the
+// current one, the one numbered revision, or the one deployed by
+// rollback_build. It waits for the restored revision to roll out.
+func (p Plugin) rollback(ctx context.Context) error {
+	p.checkCluster()
+
+	clientset, err := p.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	workloads, err := p.resolveWorkloads(ctx, clientset, "Deployment", "StatefulSet", "DaemonSet")
+	if err != nil {
+		return err
+	}
+
+	for _, w := range workloads {
+		u, err := p.getWorkload(ctx, clientset, w)
+		if err != nil {
+			return err
+		}
+		revisions, err := p.revisions(ctx, clientset, w, u)
+		if err != nil {
+			return err
+		}
+		if len(revisions) == 0 {
+			return fmt.Errorf("%s has no revision history", w)
+		}
+		printRevisions(w, revisions)
+
+		target, err := p.rollbackTarget(w, revisions)
+		if err != nil {
+			return err
+		}
+		current := revisions[len(revisions)-1]
+		if target.Number == current.Number {
+			log.Printf("Skipping %s, revision %d is the current one", w, target.Number)
+			continue
+		}
+
+		log.Printf("Rolling back %s to revision %d", w, target.Number)
+		if w.Kind == "Deployment" {
+			err = p.patchWorkload(ctx, clientset, w, types.JSONPatchType, deploymentRollbackPatch(u, target))
+		} else {
+			err = p.patchWorkload(ctx, clientset, w, types.StrategicMergePatchType, json.RawMessage(target.Patch))
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := p.waitForWorkloads(ctx, clientset, []workload{w}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackTarget picks the revision to restore among revisions, sorted by
+// number.
+func (p Plugin) rollbackTarget(w workload, revisions []revision) (revision, error) {
+	switch {
+	case p.Config.RollbackBuild > 0:
+		build := strconv.Itoa(p.Config.RollbackBuild)
+		for i := len(revisions) - 1; i >= 0; i-- {
+			if revisions[i].Build == build {
+				return revisions[i], nil
+			}
+		}
+		return revision{}, fmt.Errorf("%s has no revision deployed by build %s", w, build)
+
+	case p.Config.Revision > 0:
+		for _, r := range revisions {
+			if r.Number == int64(p.Config.Revision) {
+				return r, nil
+			}
+		}
+		return revision{}, fmt.Errorf("%s has no revision %d", w, p.Config.Revision)
+	}
+
+	if len(revisions) < 2 {
+		return revision{}, fmt.Errorf("%s has no previous revision", w)
+	}
+	return revisions[len(revisions)-2], nil
+}
+
+// revisions returns the revision history of a live workload, sorted by
+// number.
+func (p Plugin) revisions(ctx context.Context, clientset *kubernetes.Clientset, w workload, u map[string]interface{}) ([]revision, error) {
+	uid, _, _ := unstructured.NestedString(u, "metadata", "uid")
+	selector := &metav1.LabelSelector{}
+	if val, ok, _ := unstructured.NestedFieldNoCopy(u, "spec", "selector"); ok {
+		data, err := json.Marshal(val)
+		if err == nil {
+			err = json.Unmarshal(data, selector)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	options := metav1.ListOptions{LabelSelector: metav1.FormatLabelSelector(selector)}
+
+	var revisions []revision
+	err := p.retry(ctx, "revisions of "+w.String(), func() error {
+		revisions = nil
+		if w.Kind == "Deployment" {
+			list, err := clientset.AppsV1().ReplicaSets(w.Namespace).List(options)
+			if err != nil {
+				return err
+			}
+			for i := range list.Items {
+				rs := &list.Items[i]
+				if !ownedBy(rs.OwnerReferences, uid) {
+					continue
+				}
+				number, _ := strconv.ParseInt(rs.Annotations[deploymentRevisionAnnotation], 10, 64)
+				template, err := toUnstructured(rs)
+				if err != nil {
+					return err
+				}
+				template, _, _ = unstructured.NestedMap(template, "spec", "template")
+				revisions = append(revisions, p.newRevision(number, template, rs.Annotations[changeCauseAnnotation]))
+			}
+			return nil
+		}
+
+		list, err := clientset.AppsV1().ControllerRevisions(w.Namespace).List(options)
+		if err != nil {
+			return err
+		}
+		for i := range list.Items {
+			cr := &list.Items[i]
+			if !ownedBy(cr.OwnerReferences, uid) {
+				continue
+			}
+			data := map[string]interface{}{}
+			if err := json.Unmarshal(cr.Data.Raw, &data); err != nil {
+				return err
+			}
+			template, _, _ := unstructured.NestedMap(data, "spec", "template")
+			r := p.newRevision(cr.Revision, template, cr.Annotations[changeCauseAnnotation])
+			r.Patch = cr.Data.Raw
+			revisions = append(revisions, r)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error when listing the revisions of " + w.String())
+		return nil, err
+	}
+
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Number < revisions[j].Number })
+	return revisions, nil
+}
+
+// newRevision reads the build that deployed a revision from the provenance
+// or force rollout annotations of its pod template.
+func (p Plugin) newRevision(number int64, template map[string]interface{}, changeCause string) revision {
+	annotations, _, _ := unstructured.NestedStringMap(template, "metadata", "annotations")
+	build := annotations[p.Config.ProvenancePrefix+"build"]
+	if build == "" {
+		build = annotations[restartedByAnnotation]
+	}
+	return revision{Number: number, Build: build, ChangeCause: changeCause, Template: template}
+}
+
+func ownedBy(owners []metav1.OwnerReference, uid string) bool {
+	for _, owner := range owners {
+		if string(owner.UID) == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentRollbackPatch replaces the pod template of a Deployment with the
+// one of a ReplicaSet, and restores its change cause, as kubectl rollout undo
+// does.
+func deploymentRollbackPatch(u map[string]interface{}, target revision) []jsonPatchOperation {
+	template := deepCopyJSON(target.Template).(map[string]interface{})
+	unstructured.RemoveNestedField(template, "metadata", "labels", podTemplateHashLabel)
+
+	patch := []jsonPatchOperation{{Op: "replace", Path: "/spec/template", Value: template}}
+	if target.ChangeCause != "" {
+		if _, ok, _ := unstructured.NestedFieldNoCopy(u, "metadata", "annotations"); ok {
+			patch = append(patch, jsonPatchOperation{Op: "add", Path: joinPointer([]string{"metadata", "annotations", changeCauseAnnotation}), Value: target.ChangeCause})
+		} else {
+			patch = append(patch, jsonPatchOperation{Op: "add", Path: "/metadata/annotations", Value: map[string]string{changeCauseAnnotation: target.ChangeCause}})
+		}
+	}
+	return patch
+}
+
+// printRevisions logs the revision history of a workload, the current
+// revision last.
+func printRevisions(w workload, revisions []revision) {
+	log.Println("Revisions of " + w.String() + ":")
+	log.Printf("%-10s %-8s %s", "REVISION", "BUILD", "CHANGE-CAUSE")
+	for _, r := range revisions {
+		build, cause := r.Build, r.ChangeCause
+		if build == "" {
+			build = "-"
+		}
+		if cause == "" {
+			cause = "-"
+		}
+		log.Printf("%-10d %-8s %s", r.Number, build, strings.TrimSpace(cause))
+	}
+}
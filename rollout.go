@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const rolloutPollInterval = 2 * time.Second
+
+const (
+	// restartedAtAnnotation is the pod template annotation kubectl rollout
+	// restart sets.
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+	// restartedByAnnotation records the build that forced the rollout.
+	restartedByAnnotation = "drone-kubernetes/restarted-by-build"
+)
+
+// isWorkload reports whether objects of kind roll out Pods the plugin can
+// wait for.
+func isWorkload(kind string) bool {
+	switch kind {
+	case "Deployment", "StatefulSet", "DaemonSet":
+		return true
+	}
+	return false
+}
+
+// applyForceRollout stamps the pod template of every Deployment, StatefulSet
+// and DaemonSet with the build number and the current time, so that they roll
+// out even when nothing else changed, as with mutable image tags.
+func (p Plugin) applyForceRollout(manifests []manifest, now time.Time) error {
+	annotations := map[string]string{
+		restartedAtAnnotation: now.UTC().Format(time.RFC3339),
+		restartedByAnnotation: strconv.Itoa(p.Build.Number),
+	}
+	return transformManifests(manifests, func(m manifest, u map[string]interface{}) error {
+		if template := podTemplate(u); template != nil && isWorkload(m.Kind) {
+			setStringMapEntries(template, annotations, "metadata", "annotations")
+		}
+		return nil
+	})
+}
+
+// waitForRollouts waits for every Deployment, StatefulSet and DaemonSet of
+// manifests to finish rolling out.
+func (p Plugin) waitForRollouts(ctx context.Context, clientset *kubernetes.Clientset, manifests []manifest) error {
+	for _, m := range manifests {
+		if !isWorkload(m.Kind) {
+			continue
+		}
+		if err := p.waitForRollout(ctx, clientset, m.Object); err != nil {
+			log.Println("Error when waiting for the rollout of " + m.String())
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForRollout polls a workload until its rollout is complete, it fails,
+// or ctx is done.
+func (p Plugin) waitForRollout(ctx context.Context, clientset *kubernetes.Clientset, obj runtime.Object) error {
+	var last string
+	for {
+		var live runtime.Object
+		err := p.retry(ctx, "rollout status", func() error {
+			var err error
+			live, err = p.getLive(clientset, obj)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		done, status, err := rolloutStatus(live)
+		if err != nil {
+			return err
+		}
+		if status != last {
+			log.Println(status)
+			last = status
+		}
+		if done {
+			return nil
+		}
+
+		timer := time.NewTimer(rolloutPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// rolloutStatus tells whether the rollout of a live workload is complete,
+// following the rules of kubectl rollout status.
+func rolloutStatus(live runtime.Object) (bool, string, error) {
+	u, err := toUnstructured(live)
+	if err != nil {
+		return false, "", err
+	}
+	kind, _, _ := unstructured.NestedString(u, "kind")
+	name, _, _ := unstructured.NestedString(u, "metadata", "name")
+	generation, _, _ := unstructured.NestedInt64(u, "metadata", "generation")
+	observed, _, _ := unstructured.NestedInt64(u, "status", "observedGeneration")
+	status := func(field string) int64 {
+		n, _, _ := unstructured.NestedInt64(u, "status", field)
+		return n
+	}
+	prefix := kind + " " + name + ": "
+
+	if observed < generation {
+		return false, prefix + "waiting for the controller to observe the update", nil
+	}
+
+	switch kind {
+	case "Deployment":
+		for _, condition := range nestedMaps(u, "status", "conditions") {
+			if condition["type"] == "Progressing" && condition["reason"] == "ProgressDeadlineExceeded" {
+				return false, "", fmt.Errorf("deployment %s exceeded its progress deadline", name)
+			}
+		}
+		replicas, ok, _ := unstructured.NestedInt64(u, "spec", "replicas")
+		if !ok {
+			replicas = 1
+		}
+		updated := status("updatedReplicas")
+		switch {
+		case updated < replicas:
+			return false, fmt.Sprintf("%s%d of %d updated replicas", prefix, updated, replicas), nil
+		case status("replicas") > updated:
+			return false, fmt.Sprintf("%s%d old replicas pending termination", prefix, status("replicas")-updated), nil
+		case status("availableReplicas") < updated:
+			return false, fmt.Sprintf("%s%d of %d updated replicas available", prefix, status("availableReplicas"), updated), nil
+		}
+
+	case "StatefulSet":
+		strategy, _, _ := unstructured.NestedString(u, "spec", "updateStrategy", "type")
+		if strategy == "OnDelete" {
+			return true, prefix + "OnDelete update strategy, not waiting", nil
+		}
+		replicas, ok, _ := unstructured.NestedInt64(u, "spec", "replicas")
+		if !ok {
+			replicas = 1
+		}
+		if ready := status("readyReplicas"); ready < replicas {
+			return false, fmt.Sprintf("%s%d of %d replicas ready", prefix, ready, replicas), nil
+		}
+		partition, _, _ := unstructured.NestedInt64(u, "spec", "updateStrategy", "rollingUpdate", "partition")
+		if partition > 0 {
+			if current := status("updatedReplicas"); current < replicas-partition {
+				return false, fmt.Sprintf("%s%d of %d partitioned replicas updated", prefix, current, replicas-partition), nil
+			}
+			break
+		}
+		current, _, _ := unstructured.NestedString(u, "status", "currentRevision")
+		update, _, _ := unstructured.NestedString(u, "status", "updateRevision")
+		if current != update {
+			return false, fmt.Sprintf("%s%d of %d replicas updated", prefix, status("updatedReplicas"), replicas), nil
+		}
+
+	case "ReplicaSet":
+		replicas, ok, _ := unstructured.NestedInt64(u, "spec", "replicas")
+		if !ok {
+			replicas = 1
+		}
+		if current := status("replicas"); current > replicas {
+			return false, fmt.Sprintf("%s%d old replicas pending termination", prefix, current-replicas), nil
+		}
+		if ready := status("readyReplicas"); ready < replicas {
+			return false, fmt.Sprintf("%s%d of %d replicas ready", prefix, ready, replicas), nil
+		}
+
+	case "DaemonSet":
+		desired := status("desiredNumberScheduled")
+		if updated := status("updatedNumberScheduled"); updated < desired {
+			return false, fmt.Sprintf("%s%d of %d updated pods scheduled", prefix, updated, desired), nil
+		}
+		if available := status("numberAvailable"); available < desired {
+			return false, fmt.Sprintf("%s%d of %d updated pods available", prefix, available, desired), nil
+		}
+	}
+
+	return true, prefix + "rolled out", nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semVersion is a parsed semantic version (https://semver.org). A leading
+// "v" and missing minor or patch numbers are accepted, as in "v1.2".
+type semVersion struct {
+	Major      int64
+	Minor      int64
+	Patch      int64
+	Prerelease string
+	Metadata   string
+	Original   string
+}
+
+var semverPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+func parseSemver(s string) (*semVersion, error) {
+	match := semverPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return nil, fmt.Errorf("invalid semantic version %q", s)
+	}
+
+	v := &semVersion{Prerelease: match[4], Metadata: match[5], Original: s}
+	for i, field := range []*int64{&v.Major, &v.Minor, &v.Patch} {
+		if match[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(match[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version %q: %v", s, err)
+		}
+		*field = n
+	}
+
+	return v, nil
+}
+
+func (v *semVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Metadata != "" {
+		s += "+" + v.Metadata
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is lower than, equal to or greater than
+// o. Build metadata is ignored, as required by the specification.
+func (v *semVersion) Compare(o *semVersion) int {
+	for _, d := range []int64{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseInt(as[i], 10, 64)
+		bn, bErr := strconv.ParseInt(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			// numeric identifiers have lower precedence
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// semverMatches checks version against a constraint such as ">= 1.2.0",
+// "~1.2", "^1.0.0" or "> 1.0, < 2.0". Comma separated constraints must all
+// be satisfied, "||" separates alternatives.
+func semverMatches(constraint string, version string) (bool, error) {
+	v, err := parseSemver(version)
+	if err != nil {
+		return false, err
+	}
+
+	for _, alternative := range strings.Split(constraint, "||") {
+		ok := true
+		for _, c := range strings.Split(alternative, ",") {
+			match, err := semverCheck(strings.TrimSpace(c), v)
+			if err != nil {
+				return false, err
+			}
+			ok = ok && match
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+var constraintPattern = regexp.MustCompile(`^(>=|<=|!=|=|>|<|~|\^)?\s*(\S+)$`)
+
+func semverCheck(constraint string, v *semVersion) (bool, error) {
+	match := constraintPattern.FindStringSubmatch(constraint)
+	if match == nil {
+		return false, fmt.Errorf("invalid semantic version constraint %q", constraint)
+	}
+	c, err := parseSemver(match[2])
+	if err != nil {
+		return false, err
+	}
+
+	cmp := v.Compare(c)
+	switch match[1] {
+	case "", "=":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case "~":
+		// ~1.2.3 allows patch updates, ~1 allows minor updates
+		if cmp < 0 || v.Major != c.Major {
+			return false, nil
+		}
+		return strings.Count(strings.TrimPrefix(match[2], "v"), ".") == 0 || v.Minor == c.Minor, nil
+	case "^":
+		// ^1.2.3 allows anything below the next major, ^0.2.3 below 0.3.0
+		// and ^0.0.3 below 0.0.4
+		if cmp < 0 || v.Major != c.Major {
+			return false, nil
+		}
+		if c.Major == 0 && c.Minor == 0 && strings.Count(strings.TrimPrefix(match[2], "v"), ".") == 2 {
+			return v.Minor == 0 && v.Patch == c.Patch, nil
+		}
+		return c.Major > 0 || v.Minor == c.Minor, nil
+	}
+
+	return false, nil
+}
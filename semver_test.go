@@ -0,0 +1,150 @@
+package main
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{version: "1.2.3", want: "1.2.3"},
+		{version: "v1.2.3", want: "1.2.3"},
+		{version: "v1.2", want: "1.2.0"},
+		{version: "1", want: "1.0.0"},
+		{version: "1.2.3-rc.1+build.5", want: "1.2.3-rc.1+build.5"},
+		{version: "1.2.3+build", want: "1.2.3+build"},
+		{version: "release-1", wantErr: true},
+		{version: "1.2.3.4", wantErr: true},
+		{version: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		v, err := parseSemver(test.version)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.version, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.version, err)
+			continue
+		}
+		if v.String() != test.want {
+			t.Errorf("%q: got %s, want %s", test.version, v, test.want)
+		}
+	}
+}
+
+func TestSemverPrecedence(t *testing.T) {
+	// in increasing order, as listed by the specification
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := parseSemver(ordered[i])
+			b, _ := parseSemver(ordered[j])
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("compare %s with %s: got %d, want %d", a, b, got, want)
+			}
+		}
+	}
+
+	a, _ := parseSemver("1.0.0+build.1")
+	b, _ := parseSemver("1.0.0+build.2")
+	if a.Compare(b) != 0 {
+		t.Errorf("build metadata must be ignored")
+	}
+}
+
+func TestSemverMatches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"1.2.3", "v1.2.3", true},
+		{"= 1.2.3", "1.2.4", false},
+		{"!= 1.2.3", "1.2.4", true},
+		{">= 1.2.0", "1.2.0", true},
+		{"> 1.2.0", "1.2.0", false},
+		{"< 2.0", "1.9.9", true},
+		{"<= 2.0", "2.0.1", false},
+		{"> 1.0, < 2.0", "1.5.0", true},
+		{"> 1.0, < 2.0", "2.0.0", false},
+		{"< 1.0 || >= 2.0", "2.1.0", true},
+		{"< 1.0 || >= 2.0", "1.5.0", false},
+
+		// tilde allows patch updates, or minor updates without a minor
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.2.2", false},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"~1", "2.0.0", false},
+
+		// caret allows anything below the next major, or the next minor for 0.x
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.0.3", "0.0.9", false},
+		{"^0.0.3", "0.1.0", false},
+		{"^0.0", "0.0.9", true},
+		{"^0.0", "0.1.0", false},
+
+		// prereleases are lower than their release
+		{">= 1.2.0", "1.2.0-rc.1", false},
+		{"< 1.2.0", "1.2.0-rc.1", true},
+		{"^1.2.0-rc.1", "1.2.0-rc.2", true},
+		{"^1.2.0-rc.2", "1.2.0-rc.1", false},
+		{"~1.2.0-beta", "1.2.0", true},
+	}
+
+	for _, test := range tests {
+		got, err := semverMatches(test.constraint, test.version)
+		if err != nil {
+			t.Errorf("%q %q: %v", test.constraint, test.version, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q %q: got %v, want %v", test.constraint, test.version, got, test.want)
+		}
+	}
+}
+
+func TestSemverMatchesErrors(t *testing.T) {
+	for _, test := range []struct{ constraint, version string }{
+		{">= 1.0", "latest"},
+		{">> 1.0", "1.0.0"},
+		{"~ not-a-version", "1.0.0"},
+	} {
+		if _, err := semverMatches(test.constraint, test.version); err == nil {
+			t.Errorf("%q %q: expected an error", test.constraint, test.version)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// setImage changes the container images of live workloads, as kubectl set
+// image does, without any template. Only the images are patched.
+func (p Plugin) setImage(ctx context.Context) error {
+	p.checkCluster()
+
+	images := map[string]string{}
+	if err := decodeSetting("container_images", p.Config.ContainerImages, &images); err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		log.Fatal("CONTAINER_IMAGES is not defined")
+	}
+
+	clientset, err := p.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	workloads, err := p.resolveWorkloads(ctx, clientset, "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet")
+	if err != nil {
+		return err
+	}
+
+	// build every patch first, a typo in a container name must not leave
+	// some workloads patched
+	found := map[string]bool{}
+	patches := make([]map[string]interface{}, len(workloads))
+	for i, w := range workloads {
+		u, err := p.getWorkload(ctx, clientset, w)
+		if err != nil {
+			return err
+		}
+
+		// a strategic merge patch merges containers by name, only the
+		// existing ones may be listed, or they would be added
+		patch := map[string]interface{}{}
+		for _, field := range []string{"initContainers", "containers"} {
+			var patched []interface{}
+			for _, container := range nestedMaps(podTemplate(u), "spec", field) {
+				name, _ := container["name"].(string)
+				if image, ok := images[name]; ok {
+					patched = append(patched, map[string]interface{}{"name": name, "image": image})
+					found[name] = true
+				}
+			}
+			if len(patched) > 0 {
+				patch[field] = patched
+			}
+		}
+		if len(patch) > 0 {
+			patches[i] = map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"spec": patch}}}
+		}
+	}
+
+	var missing []string
+	for name := range images {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("no target has a container named %v", missing)
+	}
+
+	for i, w := range workloads {
+		if patches[i] == nil {
+			log.Println("No container to update in " + w.String())
+			continue
+		}
+
+		log.Println("Setting images of " + w.String())
+		if err := p.patchWorkload(ctx, clientset, w, types.StrategicMergePatchType, patches[i]); err != nil {
+			return err
+		}
+	}
+
+	if p.Config.Wait {
+		return p.waitForWorkloads(ctx, clientset, workloads)
+	}
+	return nil
+}
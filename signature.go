@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// signatureSuffixes are appended to a template URL, in order, to fetch its
+// detached signature when template_signature does not provide it.
+var signatureSuffixes = []string{".sig", ".asc"}
+
+// armoredPGPKeys and armoredPGPSignature start armored PGP public keys and
+// signatures.
+const (
+	armoredPGPKeys      = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	armoredPGPSignature = "-----BEGIN PGP SIGNATURE-----"
+)
+
+// publicKeys holds the keys templates may be signed with.
+type publicKeys struct {
+	ed25519 []ed25519.PublicKey
+	pgp     openpgp.EntityList
+}
+
+// verifySignature checks the detached ed25519 or PGP signature of a fetched
+// template against the configured public keys. Without require_signature, a
+// template with no signature is only reported.
+func (p Plugin) verifySignature(ctx context.Context, source string, content []byte) error {
+	if len(p.Config.TemplatePublicKeys) == 0 {
+		if p.Config.RequireSignature {
+			return fmt.Errorf("require_signature is set but template_public_keys is empty")
+		}
+		return nil
+	}
+
+	keys, err := parsePublicKeys(p.Config.TemplatePublicKeys)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := p.templateSignature(ctx, source)
+	if err != nil {
+		return err
+	}
+	if encoded == nil {
+		if p.Config.RequireSignature {
+			return fmt.Errorf("no signature found for %s", source)
+		}
+		log.Println("No signature found for " + source + ", skipping verification")
+		return nil
+	}
+
+	signer, err := keys.verify(content, encoded)
+	if err != nil {
+		log.Println("Error when verifying signature of " + source)
+		return err
+	}
+	log.Println("Verified signature of " + source + " by " + signer)
+	return nil
+}
+
+// verify checks signature, armored or binary PGP, or ed25519, against the
+// keys of its kind, and returns a description of the matching key.
+func (k publicKeys) verify(content, signature []byte) (string, error) {
+	trimmed := bytes.TrimSpace(signature)
+	if bytes.HasPrefix(trimmed, []byte(armoredPGPSignature)) {
+		return k.verifyPGP(content, trimmed, true)
+	}
+
+	decoded, err := decodeSignature(signature)
+	if err != nil {
+		// not an ed25519 signature, binary PGP signatures are the only other
+		// accepted format
+		if len(k.pgp) == 0 {
+			return "", err
+		}
+		return k.verifyPGP(content, signature, false)
+	}
+	for _, key := range k.ed25519 {
+		if ed25519.Verify(key, content, decoded) {
+			return "ed25519 key " + base64.StdEncoding.EncodeToString(key), nil
+		}
+	}
+	return "", fmt.Errorf("signature does not match any ed25519 public key")
+}
+
+func (k publicKeys) verifyPGP(content, signature []byte, armored bool) (string, error) {
+	if len(k.pgp) == 0 {
+		return "", fmt.Errorf("PGP signature but no PGP public key configured")
+	}
+
+	var signer *openpgp.Entity
+	var err error
+	if armored {
+		signer, err = openpgp.CheckArmoredDetachedSignature(k.pgp, bytes.NewReader(content), bytes.NewReader(signature))
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(k.pgp, bytes.NewReader(content), bytes.NewReader(signature))
+	}
+	if err != nil {
+		return "", fmt.Errorf("PGP signature does not match any public key: %v", err)
+	}
+
+	description := fmt.Sprintf("PGP key %X", signer.PrimaryKey.Fingerprint)
+	for name := range signer.Identities {
+		description += " (" + name + ")"
+		break
+	}
+	return description, nil
+}
+
+// templateSignature returns the signature of source, from template_signature
+// (a single signature or a JSON map of URLs to signatures) or fetched from
+// the URL followed by one of signatureSuffixes. It returns nil when there is
+// none.
+func (p Plugin) templateSignature(ctx context.Context, source string) ([]byte, error) {
+	setting := strings.TrimSpace(p.Config.TemplateSignature)
+	if strings.HasPrefix(setting, "{") {
+		signatures := map[string]string{}
+		if err := decodeSetting("template_signature", setting, &signatures); err != nil {
+			return nil, err
+		}
+		setting = signatures[source]
+	}
+	if setting != "" {
+		return []byte(setting), nil
+	}
+
+	for _, suffix := range signatureSuffixes {
+		out, err := p.download(ctx, source+suffix)
+		if statusErr, ok := err.(*httpStatusError); ok && statusErr.Code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			log.Println("Error when getting signature " + source + suffix)
+			return nil, err
+		}
+		return out, nil
+	}
+	return nil, nil
+}
+
+// parsePublicKeys decodes armored PGP public keys, and ed25519 public keys
+// given as PEM (PKIX "PUBLIC KEY" blocks), as base64 encoded PKIX or as the
+// base64 encoding of the raw 32 bytes.
+func parsePublicKeys(values []string) (publicKeys, error) {
+	var keys publicKeys
+	for _, value := range values {
+		rest := []byte(strings.TrimSpace(value))
+		if bytes.HasPrefix(rest, []byte(armoredPGPKeys)) {
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(rest))
+			if err != nil {
+				log.Println("Error when parsing template PGP public key")
+				return keys, err
+			}
+			keys.pgp = append(keys.pgp, entities...)
+			continue
+		}
+
+		if !bytes.HasPrefix(rest, []byte("-----")) {
+			raw, err := base64.StdEncoding.DecodeString(string(rest))
+			if err != nil {
+				return keys, fmt.Errorf("invalid ed25519 public key %q", value)
+			}
+			if len(raw) == ed25519.PublicKeySize {
+				keys.ed25519 = append(keys.ed25519, ed25519.PublicKey(raw))
+				continue
+			}
+			key, err := parsePKIXKey(raw)
+			if err != nil {
+				return keys, err
+			}
+			keys.ed25519 = append(keys.ed25519, key)
+			continue
+		}
+
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			key, err := parsePKIXKey(block.Bytes)
+			if err != nil {
+				return keys, err
+			}
+			keys.ed25519 = append(keys.ed25519, key)
+		}
+	}
+	return keys, nil
+}
+
+func parsePKIXKey(der []byte) (ed25519.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		log.Println("Error when parsing template public key")
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("template public key is %T, PEM keys must be ed25519, other algorithms are accepted as armored PGP keys", key)
+	}
+	return edKey, nil
+}
+
+// decodeSignature accepts raw 64 byte signatures as well as their base64
+// encoding, as written by most signing tools.
+func decodeSignature(data []byte) ([]byte, error) {
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid ed25519 signature length %d", len(signature))
+	}
+	return signature, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// snapshotKey is the key of the gzipped snapshot in the snapshot ConfigMap.
+const snapshotKey = "snapshot.yml.gz"
+
+// serverFields are the metadata fields set by the API server, removed from
+// snapshots before they are applied back.
+var serverFields = []string{"uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp", "deletionGracePeriodSeconds", "selfLink", "managedFields"}
+
+// snapshotEnabled reports whether live objects are saved before being
+// updated or deleted.
+func (p Plugin) snapshotEnabled() bool {
+	return p.Config.SnapshotFile != "" || p.Config.SnapshotConfigMap != ""
+}
+
+// snapshot saves the live version of every object of manifests, and of the
+// generated objects about to be pruned, to the snapshot file and ConfigMap.
+func (p Plugin) snapshot(ctx context.Context, clientset *kubernetes.Clientset, manifests []manifest) error {
+	var objects []manifest
+	for _, m := range manifests {
+		if !isSupported(m.Object) {
+			continue
+		}
+		var live runtime.Object
+		err := p.retry(ctx, "get "+m.String(), func() error {
+			var err error
+			live, err = p.getLive(clientset, m.Object)
+			return err
+		})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			log.Println("Error when getting the live version of " + m.String())
+			return err
+		}
+		objects = append(objects, newManifest("live", len(objects)+1, 0, live))
+	}
+
+	if p.Config.PruneGenerated {
+		pruned, err := p.prunableGenerated(ctx, clientset, manifests)
+		if err != nil {
+			return err
+		}
+		for _, obj := range pruned {
+			objects = append(objects, newManifest("live", len(objects)+1, 0, obj))
+		}
+	}
+
+	if p.Config.SnapshotFile != "" {
+		out, err := marshalManifests(objects)
+		if err != nil {
+			return err
+		}
+		// the snapshot holds the data of live Secrets
+		if err := ioutil.WriteFile(p.Config.SnapshotFile, out, 0600); err != nil {
+			log.Println("Error when writing snapshot " + p.Config.SnapshotFile)
+			return err
+		}
+	}
+	if p.Config.SnapshotConfigMap != "" {
+		// ConfigMaps are readable more widely than Secrets, which are left out
+		var withoutSecrets []manifest
+		for _, m := range objects {
+			if m.Kind == "Secret" {
+				log.Println("Leaving " + m.Kind + " " + m.Name + " out of the snapshot ConfigMap")
+				continue
+			}
+			withoutSecrets = append(withoutSecrets, m)
+		}
+		out, err := marshalManifests(withoutSecrets)
+		if err != nil {
+			return err
+		}
+		if err := p.writeSnapshotConfigMap(ctx, clientset, out); err != nil {
+			return err
+		}
+	}
+	log.Printf("Saved a snapshot of %d live objects", len(objects))
+	return nil
+}
+
+// writeSnapshotConfigMap stores a snapshot, gzipped, in the snapshot
+// ConfigMap of the target namespace, replacing the previous one.
+func (p Plugin) writeSnapshotConfigMap(ctx context.Context, clientset *kubernetes.Clientset, snapshot []byte) error {
+	var data bytes.Buffer
+	gz := gzip.NewWriter(&data)
+	if _, err := gz.Write(snapshot); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: p.Config.SnapshotConfigMap, Namespace: p.ledgerNamespace()},
+		BinaryData: map[string][]byte{snapshotKey: data.Bytes()},
+	}
+	err := p.retry(ctx, "write snapshot", func() error {
+		configMaps := clientset.CoreV1().ConfigMaps(configMap.Namespace)
+		_, err := configMaps.Update(configMap)
+		if errors.IsNotFound(err) {
+			_, err = configMaps.Create(configMap)
+		}
+		return err
+	})
+	if err != nil {
+		log.Println("Error when writing snapshot ConfigMap " + p.Config.SnapshotConfigMap)
+	}
+	return err
+}
+
+// readSnapshot returns the snapshot saved in the snapshot file, or else in
+// the snapshot ConfigMap.
+func (p Plugin) readSnapshot(ctx context.Context, clientset *kubernetes.Clientset) (renderedTemplate, error) {
+	if p.Config.SnapshotFile != "" {
+		out, err := ioutil.ReadFile(p.Config.SnapshotFile)
+		if err != nil {
+			log.Println("Error when reading snapshot " + p.Config.SnapshotFile)
+			return renderedTemplate{}, err
+		}
+		return renderedTemplate{Source: p.Config.SnapshotFile, Content: string(out)}, nil
+	}
+
+	var configMap *corev1.ConfigMap
+	err := p.retry(ctx, "read snapshot", func() error {
+		var err error
+		configMap, err = clientset.CoreV1().ConfigMaps(p.ledgerNamespace()).Get(p.Config.SnapshotConfigMap, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		log.Println("Error when reading snapshot ConfigMap " + p.Config.SnapshotConfigMap)
+		return renderedTemplate{}, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(configMap.BinaryData[snapshotKey]))
+	if err != nil {
+		return renderedTemplate{}, fmt.Errorf("snapshot ConfigMap %s: %v", p.Config.SnapshotConfigMap, err)
+	}
+	out, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return renderedTemplate{}, err
+	}
+	return renderedTemplate{Source: "ConfigMap " + p.Config.SnapshotConfigMap, Content: string(out)}, nil
+}
+
+// restore applies a snapshot back, without the fields set by the server.
+func (p Plugin) restore(ctx context.Context) error {
+	p.checkCluster()
+	if !p.snapshotEnabled() {
+		log.Fatal("SNAPSHOT_FILE or SNAPSHOT_CONFIGMAP is not defined")
+	}
+
+	clientset, err := p.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	snapshot, err := p.readSnapshot(ctx, clientset)
+	if err != nil {
+		return err
+	}
+	manifests, err := decodeManifests(snapshot)
+	if err != nil {
+		return err
+	}
+	err = transformManifests(manifests, func(m manifest, u map[string]interface{}) error {
+		stripServerFields(u)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Restoring %d objects from %s", len(manifests), snapshot.Source)
+	_, err = p.applyManifests(ctx, clientset, manifests)
+	return err
+}
+
+// stripServerFields removes the metadata and status set by the API server
+// from a live object, so that it can be applied again.
+func stripServerFields(u map[string]interface{}) {
+	for _, field := range serverFields {
+		unstructured.RemoveNestedField(u, "metadata", field)
+	}
+	delete(u, "status")
+}
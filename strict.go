@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aymerick/raymond/ast"
+	"github.com/aymerick/raymond/parser"
+)
+
+// raymondBuiltins lists the helpers raymond registers by itself.
+var raymondBuiltins = []string{"if", "unless", "with", "each", "log", "lookup", "equal"}
+
+// checkHandlebars reports every expression of a Handlebars template that does
+// not resolve against payload, since raymond silently renders those as empty
+// strings. Values printed directly must not be empty strings or nil either,
+// unless an enclosing #if or #unless tests them. Expressions whose context
+// cannot be known before rendering, such as the ones inside an #each block,
+// are not checked.
+func checkHandlebars(source, template string, payload interface{}) error {
+	program, err := parser.Parse(template)
+	if err != nil {
+		return err
+	}
+
+	c := &strictChecker{source: source, helpers: map[string]bool{}, guards: map[string]int{}}
+	for _, name := range raymondBuiltins {
+		c.helpers[name] = true
+	}
+	for name := range funcs {
+		c.helpers[name] = true
+	}
+	for name := range fileFuncs {
+		c.helpers[name] = true
+	}
+
+	root := reflect.ValueOf(payload)
+	c.program(program, []reflect.Value{root})
+
+	if len(c.errors) > 0 {
+		return fmt.Errorf("unresolved or empty template references:\n%s", strings.Join(c.errors, "\n"))
+	}
+	return nil
+}
+
+// checkDelimiters fails when rendered output still contains template
+// delimiters, which usually comes from a typo or a double rendering.
+func checkDelimiters(source, out string) error {
+	for i, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "{{") || strings.Contains(line, "}}") {
+			return fmt.Errorf("%s:%d: rendered template still contains delimiters: %s", source, i+1, strings.TrimSpace(line))
+		}
+	}
+	return nil
+}
+
+type strictChecker struct {
+	source  string
+	helpers map[string]bool
+	errors  []string
+
+	// guards counts the enclosing #if and #unless blocks testing a path
+	guards map[string]int
+}
+
+// program checks every statement of p, scopes holds the context stack, the
+// current context being last. An invalid value means the context is unknown.
+func (c *strictChecker) program(p *ast.Program, scopes []reflect.Value) {
+	if p == nil {
+		return
+	}
+	for _, node := range p.Body {
+		switch n := node.(type) {
+		case *ast.MustacheStatement:
+			c.expression(n.Expression, scopes)
+		case *ast.BlockStatement:
+			c.block(n, scopes)
+		}
+	}
+}
+
+func (c *strictChecker) block(b *ast.BlockStatement, scopes []reflect.Value) {
+	name := ""
+	if path, ok := b.Expression.Path.(*ast.PathExpression); ok {
+		name = path.Original
+	}
+
+	// testing an optional value with #if or #unless is fine
+	if name != "if" && name != "unless" {
+		c.params(b.Expression, scopes)
+	}
+
+	inner := scopes
+	switch name {
+	case "with":
+		var ctx reflect.Value
+		if len(b.Expression.Params) == 1 {
+			if path, ok := b.Expression.Params[0].(*ast.PathExpression); ok {
+				ctx, _ = c.resolve(path, scopes)
+			}
+		}
+		inner = append(scopes[:len(scopes):len(scopes)], ctx)
+	case "each":
+		// the context of each item is only known while rendering
+		inner = append(scopes[:len(scopes):len(scopes)], reflect.Value{})
+	}
+
+	// both branches may print the tested value, empty or not
+	if name == "if" || name == "unless" {
+		if len(b.Expression.Params) == 1 {
+			if path, ok := b.Expression.Params[0].(*ast.PathExpression); ok {
+				c.guards[path.Original]++
+				defer func() { c.guards[path.Original]-- }()
+			}
+		}
+	}
+
+	c.program(b.Program, inner)
+	c.program(b.Inverse, scopes)
+}
+
+func (c *strictChecker) expression(e *ast.Expression, scopes []reflect.Value) {
+	path, ok := e.Path.(*ast.PathExpression)
+	if !ok {
+		return
+	}
+	if len(e.Params) > 0 || e.Hash != nil || c.helpers[path.Original] {
+		c.params(e, scopes)
+		return
+	}
+	if val, known := c.resolve(path, scopes); known && val.IsValid() && isEmptyValue(val) && c.guards[path.Original] == 0 {
+		c.errors = append(c.errors, fmt.Sprintf("%s:%d: {{%s}} is empty", c.source, path.Line, path.Original))
+		return
+	}
+	c.check(path, scopes)
+}
+
+// isEmptyValue reports whether a printed value would render as an empty
+// string: an empty string or a nil value. Numbers and booleans are printed as
+// they are, zero or false included.
+func isEmptyValue(val reflect.Value) bool {
+	for val.Kind() == reflect.Interface && !val.IsNil() {
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.String:
+		return val.Len() == 0
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return val.IsNil()
+	}
+	return false
+}
+
+func (c *strictChecker) params(e *ast.Expression, scopes []reflect.Value) {
+	nodes := append([]ast.Node{}, e.Params...)
+	if e.Hash != nil {
+		for _, pair := range e.Hash.Pairs {
+			nodes = append(nodes, pair.Val)
+		}
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *ast.PathExpression:
+			c.check(n, scopes)
+		case *ast.SubExpression:
+			c.expression(n.Expression, scopes)
+		}
+	}
+}
+
+func (c *strictChecker) check(path *ast.PathExpression, scopes []reflect.Value) {
+	if val, known := c.resolve(path, scopes); known && !val.IsValid() {
+		c.errors = append(c.errors, fmt.Sprintf("%s:%d: {{%s}} does not resolve to any value", c.source, path.Line, path.Original))
+	}
+}
+
+// resolve evaluates path the way raymond does. The second result is false
+// when the context path is evaluated in is unknown.
+func (c *strictChecker) resolve(path *ast.PathExpression, scopes []reflect.Value) (reflect.Value, bool) {
+	parts := path.Parts
+	var ctx reflect.Value
+	switch {
+	case path.Data:
+		// only @root can be checked, @index, @key and friends are set by
+		// block helpers while rendering
+		if len(parts) == 0 || parts[0] != "root" {
+			return reflect.Value{}, false
+		}
+		ctx, parts = scopes[0], parts[1:]
+	case path.Depth >= len(scopes):
+		return reflect.Value{}, false
+	default:
+		ctx = scopes[len(scopes)-1-path.Depth]
+	}
+	if !ctx.IsValid() {
+		return ctx, false
+	}
+
+	for _, part := range parts {
+		// segment literals such as ports.[1] are looked up without brackets
+		if len(part) >= 2 && part[0] == '[' && part[len(part)-1] == ']' {
+			part = part[1 : len(part)-1]
+		}
+		ctx = resolveField(ctx, part)
+		if !ctx.IsValid() {
+			break
+		}
+	}
+	return ctx, true
+}
+
+// resolveField mirrors raymond's field lookup: exported struct fields (first
+// letter upper cased), handlebars struct tags, methods, map keys and slice
+// indexes. Nil pointers and interfaces resolve to nothing.
+func resolveField(ctx reflect.Value, name string) reflect.Value {
+	if method := ctx.MethodByName(name); method.IsValid() {
+		return method
+	}
+	for ctx.Kind() == reflect.Ptr || ctx.Kind() == reflect.Interface {
+		if ctx.IsNil() {
+			return reflect.Value{}
+		}
+		ctx = ctx.Elem()
+	}
+
+	switch ctx.Kind() {
+	case reflect.Struct:
+		if field, ok := ctx.Type().FieldByName(strings.Title(name)); ok && field.PkgPath == "" {
+			return ctx.FieldByIndex(field.Index)
+		}
+		for i := 0; i < ctx.NumField(); i++ {
+			if ctx.Type().Field(i).Tag.Get("handlebars") == name {
+				return ctx.Field(i)
+			}
+		}
+	case reflect.Map:
+		key := reflect.ValueOf(name)
+		if key.Type().AssignableTo(ctx.Type().Key()) {
+			val := ctx.MapIndex(key)
+			if val.IsValid() && val.Kind() == reflect.Interface && val.IsNil() {
+				return reflect.Value{}
+			}
+			return val
+		}
+	case reflect.Array, reflect.Slice:
+		var i int
+		if _, err := fmt.Sscanf(name, "%d", &i); err == nil && i >= 0 && i < ctx.Len() {
+			return ctx.Index(i)
+		}
+	}
+
+	return reflect.Value{}
+}
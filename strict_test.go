@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+type strictBuild struct {
+	Tag    string
+	Number int
+	Deploy bool
+	Branch string `handlebars:"ref"`
+}
+
+func TestCheckHandlebars(t *testing.T) {
+	payload := map[string]interface{}{
+		"build": strictBuild{Tag: "v1.2.3", Branch: "master"},
+		"repo": map[string]interface{}{
+			"name":   "web",
+			"owner":  "",
+			"tags":   []string{},
+			"ports":  []int{80, 443},
+			"meta":   nil,
+			"labels": map[string]string(nil),
+		},
+		"values": map[string]interface{}{"replicas": float64(0)},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{
+			name:     "resolved values",
+			template: "{{build.tag}} {{build.ref}} {{repo.name}} {{repo.ports.[1]}}",
+		},
+		{
+			name:     "unresolved paths",
+			template: "{{build.tga}}\n{{repo.missing.deep}}\n{{repo.meta}}",
+			want: []string{
+				"app.yml:1: {{build.tga}} does not resolve to any value",
+				"app.yml:2: {{repo.missing.deep}} does not resolve to any value",
+				"app.yml:3: {{repo.meta}} does not resolve to any value",
+			},
+		},
+		{
+			name:     "segment literals",
+			template: "{{repo.ports.[0]}} {{repo.ports.[2]}}",
+			want:     []string{"app.yml:1: {{repo.ports.[2]}} does not resolve to any value"},
+		},
+		{
+			name:     "empty values",
+			template: "{{repo.owner}}\n{{repo.labels}}",
+			want: []string{
+				"app.yml:1: {{repo.owner}} is empty",
+				"app.yml:2: {{repo.labels}} is empty",
+			},
+		},
+		{
+			name:     "numbers and booleans are printed as they are",
+			template: "{{build.number}} {{build.deploy}} {{values.replicas}} {{repo.tags}}",
+		},
+		{
+			name:     "#if guards the tested path",
+			template: "{{#if repo.owner}}{{repo.owner}}{{else}}{{repo.owner}}{{/if}}",
+		},
+		{
+			name:     "#unless guards the tested path",
+			template: "{{#unless repo.owner}}{{repo.owner}}{{/unless}}",
+		},
+		{
+			name:     "guards do not outlive their block",
+			template: "{{#if repo.owner}}{{/if}}{{repo.owner}}",
+			want:     []string{"app.yml:1: {{repo.owner}} is empty"},
+		},
+		{
+			name:     "#if on a missing path",
+			template: "{{#if repo.missing}}{{repo.missing}}{{/if}}",
+			want:     []string{"app.yml:1: {{repo.missing}} does not resolve to any value"},
+		},
+		{
+			name:     "#with changes the context",
+			template: "{{#with repo}}{{name}} {{nope}} {{../build.tag}}{{/with}}",
+			want:     []string{"app.yml:1: {{nope}} does not resolve to any value"},
+		},
+		{
+			name:     "#each bodies are not checked",
+			template: "{{#each repo.ports}}{{this}} {{anything}} {{@index}}{{/each}}",
+		},
+		{
+			name:     "#each on a missing path",
+			template: "{{#each repo.volumes}}{{this}}{{/each}}",
+			want:     []string{"app.yml:1: {{repo.volumes}} does not resolve to any value"},
+		},
+		{
+			name:     "@root",
+			template: "{{#with repo}}{{@root.build.tag}} {{@root.build.missing}}{{/with}}",
+			want:     []string{"app.yml:1: {{@root.build.missing}} does not resolve to any value"},
+		},
+		{
+			name:     "helper params",
+			template: "{{uppercase repo.name}} {{uppercase repo.owner}} {{truncate repo.nope 3}}",
+			want:     []string{"app.yml:1: {{repo.nope}} does not resolve to any value"},
+		},
+		{
+			name:     "sub expressions",
+			template: "{{uppercase (lowercase build.missing)}}",
+			want:     []string{"app.yml:1: {{build.missing}} does not resolve to any value"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkHandlebars("app.yml", test.template, payload)
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected errors %q", test.want)
+			}
+			want := "unresolved or empty template references:\n" + strings.Join(test.want, "\n")
+			if err.Error() != want {
+				t.Errorf("got %q, want %q", err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestCheckHandlebarsParseError(t *testing.T) {
+	if err := checkHandlebars("app.yml", "{{#if build.tag}}", nil); err == nil {
+		t.Error("expected a parse error")
+	}
+}
@@ -25,6 +25,18 @@ func RenderTrim(template string, playload interface{}) (string, error) {
 	return strings.Trim(out, " \n"), err
 }
 
+// RenderTrimWithHelpers is RenderTrim with additional helpers, registered
+// for this template only.
+func RenderTrimWithHelpers(template string, playload interface{}, helpers map[string]interface{}) (string, error) {
+	tpl, err := raymond.Parse(template)
+	if err != nil {
+		return "", err
+	}
+	tpl.RegisterHelpers(helpers)
+	out, err := tpl.Exec(playload)
+	return strings.Trim(out, " \n"), err
+}
+
 var funcs = map[string]interface{}{
 	"uppercasefirst": uppercaseFirst,
 	"uppercase":      strings.ToUpper,
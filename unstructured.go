@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// toUnstructured converts a typed object to its JSON map representation, so
+// that transformations can be written once for every kind. Integers are
+// int64, as expected by the unstructured helpers.
+func toUnstructured(obj runtime.Object) (map[string]interface{}, error) {
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// fromUnstructured decodes a JSON map back to the typed object matching its
+// apiVersion and kind.
+func fromUnstructured(u map[string]interface{}) (runtime.Object, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	return decodeObject(data)
+}
+
+// transformManifests calls transform on the JSON map representation of
+// every manifest, then decodes the result back in place.
+func transformManifests(manifests []manifest, transform func(m manifest, u map[string]interface{}) error) error {
+	for i, m := range manifests {
+		u, err := toUnstructured(m.Object)
+		if err != nil {
+			return err
+		}
+		if err := transform(m, u); err != nil {
+			return err
+		}
+		obj, err := fromUnstructured(u)
+		if err != nil {
+			log.Println("Error when decoding transformed " + m.String())
+			return err
+		}
+		manifests[i] = newManifest(m.Source, m.Index, m.Item, obj)
+	}
+	return nil
+}
+
+// podTemplatePath returns the path to the pod template of a workload kind,
+// or nil when the kind does not have one. For Pods, the object itself is the
+// template.
+func podTemplatePath(kind string) []string {
+	switch kind {
+	case "Deployment", "DaemonSet", "ReplicaSet", "StatefulSet", "ReplicationController", "Job":
+		return []string{"spec", "template"}
+	case "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template"}
+	case "Pod":
+		return []string{}
+	}
+	return nil
+}
+
+// podTemplate returns the pod template of a workload, nil if it has none.
+func podTemplate(u map[string]interface{}) map[string]interface{} {
+	kind, _, _ := unstructured.NestedString(u, "kind")
+	path := podTemplatePath(kind)
+	if path == nil {
+		return nil
+	}
+	if len(path) == 0 {
+		return u
+	}
+	val, _, _ := unstructured.NestedFieldNoCopy(u, path...)
+	template, _ := val.(map[string]interface{})
+	return template
+}
+
+// setStringMapEntries merges entries into the string map at path, creating
+// it when needed.
+func setStringMapEntries(u map[string]interface{}, entries map[string]string, path ...string) {
+	if len(entries) == 0 {
+		return
+	}
+	val, _, _ := unstructured.NestedFieldNoCopy(u, path...)
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{}
+	}
+	for k, v := range entries {
+		m[k] = v
+	}
+	if !ok {
+		unstructured.SetNestedField(u, m, path...)
+	}
+}
+
+// containers returns every container, init containers included, of a pod
+// template.
+func containers(template map[string]interface{}) []map[string]interface{} {
+	return append(nestedMaps(template, "spec", "initContainers"), nestedMaps(template, "spec", "containers")...)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// valuesEnvPrefix marks environment variables overriding a single template
+// value, nested keys are separated by a double underscore, e.g.
+// PLUGIN_VARS_RESOURCES__LIMITS__CPU=500m.
+const valuesEnvPrefix = "PLUGIN_VARS_"
+
+// loadValues builds the Values exposed to templates: values files merged in
+// order, then the vars setting, then PLUGIN_VARS_* environment variables.
+// Later sources override earlier ones, maps are merged key by key.
+func (p Plugin) loadValues() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	for _, file := range p.Config.ValuesFiles {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Println("Error when reading values file " + file)
+			return nil, err
+		}
+		fileValues := map[string]interface{}{}
+		if err := yaml.Unmarshal(out, &fileValues); err != nil {
+			log.Println("Error when decoding values file " + file)
+			return nil, err
+		}
+		mergeValues(values, fileValues)
+	}
+
+	if p.Config.Vars != "" {
+		vars := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(p.Config.Vars), &vars); err != nil {
+			log.Println("Error when decoding vars")
+			return nil, err
+		}
+		mergeValues(values, vars)
+	}
+
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, valuesEnvPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(env, valuesEnvPrefix), "=", 2)
+		if parts[0] == "" || len(parts) != 2 {
+			continue
+		}
+		setValue(values, strings.Split(parts[0], "__"), parseValue(parts[1]))
+	}
+
+	return values, nil
+}
+
+// mergeValues deeply merges src into dst.
+func mergeValues(dst, src map[string]interface{}) {
+	for key, val := range src {
+		srcMap, srcIsMap := val.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[key] = val
+	}
+}
+
+// setValue sets the value at path, matching existing keys case-insensitively
+// since environment variable names are upper case. Missing keys are created
+// in lower case.
+func setValue(values map[string]interface{}, path []string, val interface{}) {
+	key := strings.ToLower(path[0])
+	for existing := range values {
+		if strings.EqualFold(existing, path[0]) {
+			key = existing
+			break
+		}
+	}
+
+	if len(path) == 1 {
+		values[key] = val
+		return
+	}
+
+	child, ok := values[key].(map[string]interface{})
+	if !ok {
+		child = map[string]interface{}{}
+		values[key] = child
+	}
+	setValue(child, path[1:], val)
+}
+
+// parseValue reads an environment value as YAML, so that numbers, booleans
+// and lists keep their type, and falls back to the raw string.
+func parseValue(s string) interface{} {
+	var val interface{}
+	if err := yaml.Unmarshal([]byte(s), &val); err != nil || val == nil {
+		return s
+	}
+	return val
+}
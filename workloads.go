@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// workloadResources maps the kinds the workload actions operate on to their
+// apps/v1 resource.
+var workloadResources = map[string]string{
+	"Deployment":  "deployments",
+	"StatefulSet": "statefulsets",
+	"DaemonSet":   "daemonsets",
+	"ReplicaSet":  "replicasets",
+}
+
+type (
+	// workloadTarget selects live workloads by name or by label selector.
+	workloadTarget struct {
+		Kind      string `json:"kind"`
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		Selector  string `json:"selector"`
+	}
+
+	// workload is a live workload selected by a target.
+	workload struct {
+		Kind      string
+		Namespace string
+		Name      string
+	}
+)
+
+func (w workload) String() string {
+	return w.Kind + " " + w.Namespace + "/" + w.Name
+}
+
+// resolveWorkloads returns the workloads selected by the targets setting.
+// Kinds default to Deployment and must be one of kinds.
+func (p Plugin) resolveWorkloads(ctx context.Context, clientset *kubernetes.Clientset, kinds ...string) ([]workload, error) {
+	var targets []workloadTarget
+	if err := decodeSetting("targets", p.Config.Targets, &targets); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		log.Fatal("TARGETS is not defined")
+	}
+
+	var workloads []workload
+	for _, target := range targets {
+		if target.Kind == "" {
+			target.Kind = "Deployment"
+		}
+		if !containsString(kinds, target.Kind) {
+			return nil, fmt.Errorf("action %s does not support kind %s", p.Config.Action, target.Kind)
+		}
+		namespace := target.Namespace
+		if namespace == "" {
+			namespace = p.Config.Namespace
+		}
+
+		switch {
+		case target.Name != "" && target.Selector == "":
+			workloads = append(workloads, workload{Kind: target.Kind, Namespace: namespace, Name: target.Name})
+		case target.Name == "" && target.Selector != "":
+			names, err := p.listWorkloads(ctx, clientset, target.Kind, namespace, target.Selector)
+			if err != nil {
+				return nil, err
+			}
+			if len(names) == 0 {
+				return nil, fmt.Errorf("no %s matches selector %s in namespace %s", target.Kind, target.Selector, namespace)
+			}
+			for _, name := range names {
+				workloads = append(workloads, workload{Kind: target.Kind, Namespace: namespace, Name: name})
+			}
+		default:
+			return nil, fmt.Errorf("targets need either a name or a selector")
+		}
+	}
+	return workloads, nil
+}
+
+// listWorkloads returns the names of the workloads of kind matching a label
+// selector, sorted.
+func (p Plugin) listWorkloads(ctx context.Context, clientset *kubernetes.Clientset, kind, namespace, selector string) ([]string, error) {
+	var list struct {
+		Items []struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		} `json:"items"`
+	}
+	err := p.retry(ctx, "list "+kind, func() error {
+		out, err := clientset.AppsV1().RESTClient().Get().
+			Namespace(namespace).
+			Resource(workloadResources[kind]).
+			VersionedParams(&metav1.ListOptions{LabelSelector: selector}, scheme.ParameterCodec).
+			Do().
+			Raw()
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(out, &list)
+	})
+	if err != nil {
+		log.Println("Error when listing " + kind + " matching " + selector)
+		return nil, err
+	}
+
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.Metadata.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// getWorkload returns the live workload as a map.
+func (p Plugin) getWorkload(ctx context.Context, clientset *kubernetes.Clientset, w workload) (map[string]interface{}, error) {
+	obj, err := newLiveObject(w.Kind, w.Namespace, w.Name)
+	if err != nil {
+		return nil, err
+	}
+	var u map[string]interface{}
+	err = p.retry(ctx, "get "+w.String(), func() error {
+		live, err := p.getLive(clientset, obj)
+		if err != nil {
+			return err
+		}
+		u, err = toUnstructured(live)
+		return err
+	})
+	if err != nil {
+		log.Println("Error when getting " + w.String())
+	}
+	return u, err
+}
+
+// patchWorkload sends a patch to the workload, or to one of its subresources.
+func (p Plugin) patchWorkload(ctx context.Context, clientset *kubernetes.Clientset, w workload, patchType types.PatchType, patch interface{}, subresources ...string) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	err = p.retry(ctx, "patch "+w.String(), func() error {
+		return clientset.AppsV1().RESTClient().Patch(patchType).
+			Namespace(w.Namespace).
+			Resource(workloadResources[w.Kind]).
+			Name(w.Name).
+			SubResource(subresources...).
+			Body(data).
+			Do().
+			Error()
+	})
+	if err != nil {
+		log.Println("Error when patching " + w.String())
+	}
+	return err
+}
+
+// waitForWorkloads waits for the rollout of workloads to complete.
+func (p Plugin) waitForWorkloads(ctx context.Context, clientset *kubernetes.Clientset, workloads []workload) error {
+	for _, w := range workloads {
+		obj, err := newLiveObject(w.Kind, w.Namespace, w.Name)
+		if err != nil {
+			return err
+		}
+		if err := p.waitForRollout(ctx, clientset, obj); err != nil {
+			log.Println("Error when waiting for the rollout of " + w.String())
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}